@@ -0,0 +1,46 @@
+// Command konfigsecrets manages the encrypted local secrets file used by
+// konfig in the LOCAL runtime.
+//
+// Usage:
+//
+//	konfigsecrets [-file secrets.enc.yaml] [-key secrets.key] keygen
+//	konfigsecrets [-file secrets.enc.yaml] [-key secrets.key] edit
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/mscno/konfig/koanfgcp"
+	"os"
+)
+
+func main() {
+	file := flag.String("file", "secrets.enc.yaml", "encrypted secrets file")
+	key := flag.String("key", "secrets.key", "key file")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] keygen|edit\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch flag.Arg(0) {
+	case "keygen":
+		err = koanfgcp.GenerateLocalSecretsKey(*key)
+	case "edit":
+		err = koanfgcp.EditLocalSecrets(*file, *key, "")
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
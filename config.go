@@ -14,6 +14,7 @@ import (
 	"github.com/pkg/errors"
 	"os"
 	"strings"
+	"time"
 )
 
 // Config Strategy
@@ -34,12 +35,40 @@ type Konfig struct {
 	defaults         Defaults
 	runtimeOverrides RuntimeOverrides
 	configPath       string
+	secretResolver   SecretResolver
+	secretMountDir   string
+	localSecrets     string
+	localSecretsKey  string
+	secretCache      *koanfgcp.Cache
+	secretTimeout    time.Duration
+	secretDeadline   time.Duration
+	secretRetry      koanfgcp.Retry
+	secretQPS        float64
+	secretBurst      int
+	regionalSecrets  bool
+	secretVersions   map[string]string
+	secretKeys       map[string]struct{}
+	secretState      secretState
 }
 
 func (k *Konfig) K() *koanf.Koanf {
 	return k.Koanf
 }
 
+// SecretResolver fetches secret values for InitializeConfig. The secrets
+// map passed to Resolve holds koanf key to secret reference pairs, as
+// declared by gcpsecret struct tags, and the returned map holds the
+// resolved values keyed by the same koanf keys.
+type SecretResolver interface {
+	Resolve(ctx context.Context, secrets map[string]string) (map[string]interface{}, error)
+}
+
+// SecretNamer is implemented by secret resolvers that collect their secret
+// references from a struct tag other than gcpsecret, such as koanfvault.
+type SecretNamer interface {
+	SecretNames(cfg interface{}) (map[string]string, error)
+}
+
 type Option func(k *Konfig)
 
 func WithDefaults(defaults Defaults) Option {
@@ -60,6 +89,85 @@ func WithConfigPath(configPath string) Option {
 	}
 }
 
+// WithSecretResolver replaces the default Secret Manager backed resolver.
+func WithSecretResolver(resolver SecretResolver) Option {
+	return func(k *Konfig) {
+		k.secretResolver = resolver
+	}
+}
+
+// WithSecretMountDir makes the default resolver read secrets mounted as
+// files in dir before calling the Secret Manager API.
+func WithSecretMountDir(dir string) Option {
+	return func(k *Konfig) {
+		k.secretMountDir = dir
+	}
+}
+
+// WithLocalSecrets resolves secrets from the encrypted secrets file at path,
+// decrypted with the key in keyPath, when running in the LOCAL runtime and
+// the file exists. See koanfgcp.LocalSecrets.
+func WithLocalSecrets(path, keyPath string) Option {
+	return func(k *Konfig) {
+		k.localSecrets = path
+		k.localSecretsKey = keyPath
+	}
+}
+
+// WithSecretCache makes the default resolver cache fetched secrets in cache,
+// created with koanfgcp.NewCache. Keep cache to invalidate secrets. The cache
+// is only used outside the CLOUD runtime.
+func WithSecretCache(cache *koanfgcp.Cache) Option {
+	return func(k *Konfig) {
+		k.secretCache = cache
+	}
+}
+
+// WithSecretTimeouts bounds every Secret Manager request of the default
+// resolver by secretTimeout and fetching all secrets by deadline.
+func WithSecretTimeouts(secretTimeout, deadline time.Duration) Option {
+	return func(k *Konfig) {
+		k.secretTimeout = secretTimeout
+		k.secretDeadline = deadline
+	}
+}
+
+// WithSecretRetry retries Secret Manager requests of the default resolver
+// that fail with transient errors.
+func WithSecretRetry(retry koanfgcp.Retry) Option {
+	return func(k *Konfig) {
+		k.secretRetry = retry
+	}
+}
+
+// WithSecretQPS limits the Secret Manager requests per second of the
+// default resolver. See koanfgcp.Config.QPS.
+func WithSecretQPS(qps float64, burst int) Option {
+	return func(k *Konfig) {
+		k.secretQPS = qps
+		k.secretBurst = burst
+	}
+}
+
+// WithRegionalSecrets makes the default resolver read every secret as a
+// regional secret in the detected region. Without it only gcpsecret tags with
+// the regional option or a location are regional.
+func WithRegionalSecrets() Option {
+	return func(k *Konfig) {
+		k.regionalSecrets = true
+	}
+}
+
+// WithSecretVersions makes the default resolver pin secrets to a version
+// number or alias, keyed by secret name, e.g. {"DB_PASS": "6"} to roll DB_PASS
+// back without changing its gcpsecret tag. The pins take precedence over the
+// versions given in gcpsecret tags. See koanfgcp.Config.Versions.
+func WithSecretVersions(versions map[string]string) Option {
+	return func(k *Konfig) {
+		k.secretVersions = versions
+	}
+}
+
 func NewKonfig(projects Set, defaultRegion string, opts ...Option) *Konfig {
 	k := koanf.New(".")
 	ko := &Konfig{
@@ -286,16 +394,9 @@ func (k *Konfig) InitializeConfig(ctx context.Context, pointer interface{}) erro
 	}
 
 	cfg := pointer
-	gcpKoanfProvider, err := koanfgcp.Provider(ctx,
-		koanfgcp.Config{Project: string(k.Project()), SkipKeys: k.Keys()},
-		cfg,
-		func(s string) string { return s })
-	if err != nil {
-		return errors.Wrap(err, "could not initialize gcp config provider")
-	}
-	err = k.Load(gcpKoanfProvider, nil)
+	err = k.loadSecrets(ctx, cfg)
 	if err != nil {
-		return errors.Wrap(err, "could not load gcp config")
+		return err
 	}
 
 	// resolveSecrets
@@ -312,6 +413,133 @@ func (k *Konfig) InitializeConfig(ctx context.Context, pointer interface{}) erro
 	return nil
 }
 
+func (k *Konfig) loadSecrets(ctx context.Context, cfg interface{}) error {
+	// Unmarshal what is loaded so far so that secrets inside slices and maps
+	// of structs are found for every element
+	err := k.Unmarshal("", cfg)
+	if err != nil {
+		return errors.Wrap(err, "could not unmarshal config")
+	}
+
+	secretNames := koanfgcp.SecretNames
+	if namer, ok := k.secretResolver.(SecretNamer); ok {
+		secretNames = namer.SecretNames
+	}
+
+	secrets, err := secretNames(cfg)
+	if err != nil {
+		return errors.Wrap(err, "could not resolve secret names")
+	}
+
+	secrets, err = k.expandSecretNames(secrets)
+	if err != nil {
+		return errors.Wrap(err, "could not expand secret names")
+	}
+
+	// Keys that already have a value from defaults, overrides or the config file are not fetched
+	raw := k.Raw()
+	for key := range secrets {
+		if k.hasValue(raw, key) {
+			delete(secrets, key)
+		}
+	}
+
+	// Placeholders are resolved in the same call, so a secret used both in a
+	// tag and in a placeholder is fetched once
+	placeholders := k.secretPlaceholders()
+	if len(secrets) == 0 && len(placeholders.secrets) == 0 {
+		return nil
+	}
+
+	toResolve := placeholders.resolverKeys(secrets)
+
+	resolver, err := k.resolver(ctx)
+	if err != nil {
+		return err
+	}
+
+	values, err := resolver.Resolve(ctx, toResolve)
+	if err != nil {
+		return errors.Wrap(placeholders.renameErrors(err), "could not resolve secrets")
+	}
+
+	interpolated, err := placeholders.interpolate(values, nil)
+	if err != nil {
+		return err
+	}
+
+	// Interpolated values are set first so that structured secrets replace them
+	for key, value := range interpolated {
+		if err := k.Set(key, value); err != nil {
+			return errors.Wrap(err, "could not set interpolated value")
+		}
+	}
+	k.recordSecretKeys(interpolated)
+
+	secretValues := placeholders.withoutPlaceholders(values)
+	err = loadSecretValues(k.Koanf, secretValues)
+	if err != nil {
+		return errors.Wrap(err, "could not load secrets")
+	}
+	k.recordSecretKeys(secretValues)
+	k.secretState.remember(resolver, toResolve, placeholders, values)
+	return nil
+}
+
+// resolver returns the configured secret resolver, creating the default one
+// on first use.
+func (k *Konfig) resolver(ctx context.Context) (SecretResolver, error) {
+	if k.secretResolver != nil {
+		return k.secretResolver, nil
+	}
+
+	resolver, err := k.defaultSecretResolver(ctx)
+	if err != nil {
+		return nil, err
+	}
+	k.secretResolver = resolver
+	return resolver, nil
+}
+
+func (k *Konfig) defaultSecretResolver(ctx context.Context) (SecretResolver, error) {
+	if k.Runtime() == LOCAL && k.localSecrets != "" {
+		if _, err := os.Stat(k.localSecrets); err == nil {
+			resolver, err := koanfgcp.LoadLocalSecrets(k.localSecrets, k.localSecretsKey)
+			if err != nil {
+				return nil, errors.Wrap(err, "could not load local secrets")
+			}
+			return resolver, nil
+		}
+	}
+
+	resolver, err := koanfgcp.Provider(ctx, k.secretProviderConfig(), nil, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not initialize gcp config provider")
+	}
+	return resolver, nil
+}
+
+// secretProviderConfig returns the koanfgcp configuration of the default
+// resolver.
+func (k *Konfig) secretProviderConfig() koanfgcp.Config {
+	cfg := koanfgcp.Config{
+		Project:       string(k.Project()),
+		MountDir:      k.secretMountDir,
+		SecretTimeout: k.secretTimeout,
+		Deadline:      k.secretDeadline,
+		Retry:         k.secretRetry,
+		QPS:           k.secretQPS,
+		Burst:         k.secretBurst,
+		Region:        k.String(regionKey),
+		Regional:      k.regionalSecrets,
+		Versions:      k.secretVersions,
+	}
+	if k.Runtime() != CLOUD {
+		cfg.Cache = k.secretCache
+	}
+	return cfg
+}
+
 func (k *Konfig) OnGcp() bool {
 	return k.Get("gcp").(bool)
 }
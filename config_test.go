@@ -2,9 +2,18 @@ package konfig
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"github.com/knadh/koanf/v2"
+	"github.com/mscno/konfig/koanfaws"
+	"github.com/mscno/konfig/koanfgcp"
+	"github.com/mscno/konfig/koanfgcptest"
 	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 var testProjectSet = Set{"playground-mscno", "playground-mscno", "playground-mscno"}
@@ -66,7 +75,8 @@ func TestGcpResolvingConfig(t *testing.T) {
 	ctx := context.Background()
 	k := NewKonfig(
 		testProjectSet,
-		"us-central1")
+		"us-central1",
+		WithSecretResolver(newTestGcpResolver(t, map[string]string{"playground-mscno/TEST_SECRET": "secret"})))
 
 	type Config struct {
 		Project          string `koanf:"project" validate:"required"`
@@ -102,7 +112,8 @@ func TestOverridesOkResolvingConfig(t *testing.T) {
 			CI: func(k *koanf.Koanf) error {
 				return k.Set("region", "europe-west1")
 			},
-		}))
+		}),
+		WithSecretResolver(newTestGcpResolver(t, map[string]string{"playground-mscno/TEST_SECRET": "secret"})))
 
 	type Config struct {
 		Project          string `koanf:"project" validate:"required"`
@@ -129,7 +140,11 @@ func TestOverridesOkResolvingConfig(t *testing.T) {
 
 func TestGcpOkSkipFromFile(t *testing.T) {
 	ctx := context.Background()
-	k := NewKonfig(testProjectSet, "us-central1", WithDefaults(Defaults{"test_secret_non_existing": "value"}))
+	k := NewKonfig(
+		testProjectSet,
+		"us-central1",
+		WithDefaults(Defaults{"test_secret_non_existing": "value"}),
+		WithSecretResolver(newTestGcpResolver(t, map[string]string{"playground-mscno/TEST_SECRET": "secret"})))
 
 	type Config struct {
 		TestSecret            string `koanf:"test_secret" validate:"required" gcpsecret:"TEST_SECRET"`
@@ -180,3 +195,247 @@ func TestDefaultsOkResolvingConfig(t *testing.T) {
 	assert.Equal(t, "global", cfg.Host)
 	assert.Equal(t, "8080", cfg.Port)
 }
+
+// newTestGcpResolver returns a koanfgcp resolver backed by an in-process
+// Secret Manager server seeded with secrets.
+func newTestGcpResolver(t *testing.T, secrets map[string]string) SecretResolver {
+	srv := koanfgcptest.NewServer(secrets)
+	t.Cleanup(srv.Close)
+	client, err := srv.Client(context.Background())
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() { client.Close() })
+	return koanfgcp.ProviderWithClient(koanfgcp.Config{Project: testProjectSet[1].(string)}, nil, client)
+}
+
+type fakeSecretResolver map[string]string
+
+func (f fakeSecretResolver) Resolve(ctx context.Context, secrets map[string]string) (map[string]interface{}, error) {
+	res := make(map[string]interface{}, len(secrets))
+	for key, name := range secrets {
+		value, ok := f[name]
+		if !ok {
+			return nil, errors.New("secret not found: " + name)
+		}
+		res[key] = value
+	}
+	return res, nil
+}
+
+func TestSecretResolverConfig(t *testing.T) {
+	ctx := context.Background()
+	k := NewKonfig(
+		testProjectSet,
+		"us-central1",
+		WithDefaults(Defaults{"from_defaults": "default"}),
+		WithSecretResolver(fakeSecretResolver{"TEST_SECRET": "secret"}))
+
+	type Config struct {
+		TestSecret       string `koanf:"test_secret" validate:"required" gcpsecret:"TEST_SECRET"`
+		FromDefaults     string `koanf:"from_defaults" validate:"required" gcpsecret:"NON_EXISTING"`
+		TestNestedStruct struct {
+			TestSecret string `koanf:"test_secret" validate:"required" gcpsecret:"TEST_SECRET"`
+		} `koanf:"test_nested_struct"`
+	}
+
+	cfg := &Config{}
+	err := k.InitializeConfig(ctx, cfg)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, "secret", cfg.TestSecret)
+	assert.Equal(t, "secret", cfg.TestNestedStruct.TestSecret)
+	assert.Equal(t, "default", cfg.FromDefaults)
+}
+
+type fakeNamedSecretResolver struct {
+	fakeSecretResolver
+	names map[string]string
+}
+
+func (f fakeNamedSecretResolver) SecretNames(cfg interface{}) (map[string]string, error) {
+	return f.names, nil
+}
+
+func TestSecretNamerConfig(t *testing.T) {
+	ctx := context.Background()
+	k := NewKonfig(
+		testProjectSet,
+		"us-central1",
+		WithSecretResolver(fakeNamedSecretResolver{
+			fakeSecretResolver: fakeSecretResolver{"kv/data/app#password": "secret"},
+			names:              map[string]string{"password": "kv/data/app#password"},
+		}))
+
+	type Config struct {
+		Password string `koanf:"password" validate:"required" vaultsecret:"kv/data/app#password"`
+		Ignored  string `koanf:"ignored" gcpsecret:"IGNORED"`
+	}
+
+	cfg := &Config{}
+	err := k.InitializeConfig(ctx, cfg)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, "secret", cfg.Password)
+	assert.Empty(t, cfg.Ignored)
+}
+
+func TestLocalSecretsConfig(t *testing.T) {
+	dir := t.TempDir()
+	path, keyPath := filepath.Join(dir, "secrets.enc.yaml"), filepath.Join(dir, "secrets.key")
+	if err := koanfgcp.GenerateLocalSecretsKey(keyPath); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := koanfgcp.WriteLocalSecrets(path, keyPath, map[string]string{"TEST_SECRET": "local"}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	ctx := context.Background()
+	k := NewKonfig(testProjectSet, "us-central1", WithLocalSecrets(path, keyPath))
+	k.SetRuntime(LOCAL)
+
+	type Config struct {
+		TestSecret string `koanf:"test_secret" validate:"required" gcpsecret:"TEST_SECRET"`
+	}
+
+	cfg := &Config{}
+	err := k.InitializeConfig(ctx, cfg)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, "local", cfg.TestSecret)
+}
+
+func TestNilPointerWithoutSecretsConfig(t *testing.T) {
+	ctx := context.Background()
+	k := NewKonfig(testProjectSet, "us-central1", WithSecretResolver(fakeSecretResolver{}))
+
+	type Config struct {
+		Feature *struct {
+			URL string `koanf:"url" validate:"required"`
+		} `koanf:"feature"`
+	}
+
+	cfg := &Config{}
+	err := k.InitializeConfig(ctx, cfg)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Nil(t, cfg.Feature)
+}
+
+func TestStructuredSecretMergedOverDefaults(t *testing.T) {
+	ctx := context.Background()
+	k := NewKonfig(
+		testProjectSet,
+		"us-central1",
+		WithDefaults(Defaults{"db.port": 5432, "db.user": "default"}),
+		WithSecretResolver(newTestGcpResolver(t, map[string]string{
+			"playground-mscno/DB_CREDS": `{"user": "app", "password": "hunter2"}`,
+		})))
+
+	type DB struct {
+		User     string `koanf:"user"`
+		Password string `koanf:"password"`
+		Port     int    `koanf:"port"`
+	}
+
+	type Config struct {
+		DB DB `koanf:"db" gcpsecret:"DB_CREDS,json"`
+	}
+
+	cfg := &Config{}
+	err := k.InitializeConfig(ctx, cfg)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, DB{User: "app", Password: "hunter2", Port: 5432}, cfg.DB)
+}
+
+func TestAwsSecretNamerConfig(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var in struct{ SecretId string }
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		if in.SecretId != "prod/db-pass" {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"__type": "ResourceNotFoundException", "message": in.SecretId})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"Name": in.SecretId, "VersionId": "v1", "SecretString": "secret"})
+	}))
+	defer srv.Close()
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_REGION", "us-east-1")
+
+	ctx := context.Background()
+	resolver, err := koanfaws.Provider(ctx, koanfaws.Config{Endpoint: srv.URL}, nil, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	k := NewKonfig(testProjectSet, "us-central1", WithSecretResolver(resolver))
+
+	type Config struct {
+		Password string `koanf:"password" validate:"required" awssecret:"prod/db-pass" gcpsecret:"GCP_DB_PASS"`
+	}
+
+	cfg := &Config{}
+	err = k.InitializeConfig(ctx, cfg)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, "secret", cfg.Password)
+}
+
+func TestSecretVersionsConfig(t *testing.T) {
+	ctx := context.Background()
+	srv := koanfgcptest.NewServer(map[string]string{"playground-mscno/DB_PASS": "v1"})
+	t.Cleanup(srv.Close)
+	srv.AddVersion("playground-mscno/DB_PASS", "v2")
+	client, err := srv.Client(ctx)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	t.Cleanup(func() { client.Close() })
+
+	k := NewKonfig(testProjectSet, "us-central1", WithSecretVersions(map[string]string{"DB_PASS": "1"}))
+	assert.NoError(t, k.Set(projectKey, testProjectSet[1]))
+	cfg := k.secretProviderConfig()
+	assert.Equal(t, map[string]string{"DB_PASS": "1"}, cfg.Versions)
+
+	k.secretResolver = koanfgcp.ProviderWithClient(cfg, nil, client)
+
+	type Config struct {
+		Password string `koanf:"password" gcpsecret:"DB_PASS"`
+	}
+
+	c := &Config{}
+	err = k.InitializeConfig(ctx, c)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	assert.Equal(t, "v1", c.Password, "the pin must roll DB_PASS back to version 1")
+}
+
+func TestSecretCacheConfig(t *testing.T) {
+	cache, err := koanfgcp.NewCache(t.TempDir(), filepath.Join(t.TempDir(), "cache.key"), time.Hour)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	k := NewKonfig(testProjectSet, "us-central1", WithSecretCache(cache))
+	assert.NoError(t, k.Set(projectKey, testProjectSet[1]))
+	assert.NoError(t, k.Set(runtimeKey, LOCAL))
+	assert.Same(t, cache, k.secretProviderConfig().Cache)
+
+	assert.NoError(t, k.Set(runtimeKey, CLOUD))
+	assert.Nil(t, k.secretProviderConfig().Cache, "the cache must be disabled in the CLOUD runtime")
+}
@@ -0,0 +1,160 @@
+package konfig
+
+import (
+	"fmt"
+	"github.com/mscno/konfig/koanfgcp"
+	"github.com/pkg/errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// secretPlaceholder matches ${gcpsecret:REF} placeholders in config values,
+// where REF is any reference accepted by the gcpsecret tag.
+var secretPlaceholder = regexp.MustCompile(`\$\{gcpsecret:([^}]+)\}`)
+
+// placeholderKeyPrefix starts the keys under which placeholder references are
+// resolved. No koanf key starts with it, so they never clash with config keys.
+const placeholderKeyPrefix = "${placeholder}."
+
+// secretPlaceholders are the secret placeholders in string values, e.g.
+// postgres://app:${gcpsecret:DB_PASS}@db:5432/app, to be resolved together
+// with the tagged secrets.
+type secretPlaceholders struct {
+	// values holds the config values containing placeholders by koanf key
+	values map[string]string
+	// keys holds the resolver key of each placeholder
+	keys map[string]string
+	// secrets holds the reference of each resolver key
+	secrets map[string]string
+}
+
+// secretPlaceholders collects the secret placeholders in the config. A
+// placeholder used several times gets one resolver key.
+func (k *Konfig) secretPlaceholders() secretPlaceholders {
+	p := secretPlaceholders{values: map[string]string{}, keys: map[string]string{}, secrets: map[string]string{}}
+	for key, value := range k.All() {
+		s, ok := value.(string)
+		if !ok {
+			continue
+		}
+		matches := secretPlaceholder.FindAllStringSubmatch(s, -1)
+		if len(matches) == 0 {
+			continue
+		}
+		p.values[key] = s
+		for _, match := range matches {
+			if _, ok := p.keys[match[0]]; ok {
+				continue
+			}
+			resolverKey := placeholderKeyPrefix + strconv.Itoa(len(p.keys))
+			p.keys[match[0]] = resolverKey
+			p.secrets[resolverKey] = match[1]
+		}
+	}
+	return p
+}
+
+// resolverKeys returns the config key to reference pairs of secrets merged
+// with the placeholder resolver key to reference pairs, to resolve both in
+// one call.
+func (p secretPlaceholders) resolverKeys(secrets map[string]string) map[string]string {
+	merged := make(map[string]string, len(secrets)+len(p.secrets))
+	for key, ref := range secrets {
+		merged[key] = ref
+	}
+	for key, ref := range p.secrets {
+		merged[key] = ref
+	}
+	return merged
+}
+
+// configKeys returns the keys of the config values containing the placeholder
+// resolved under resolverKey, in order.
+func (p secretPlaceholders) configKeys(resolverKey string) []string {
+	var keys []string
+	for placeholder, key := range p.keys {
+		if key != resolverKey {
+			continue
+		}
+		for configKey, value := range p.values {
+			if strings.Contains(value, placeholder) {
+				keys = append(keys, configKey)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// withoutPlaceholders returns the resolved values of the config keys.
+func (p secretPlaceholders) withoutPlaceholders(values map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		if _, ok := p.secrets[key]; !ok {
+			res[key] = value
+		}
+	}
+	return res
+}
+
+// interpolate returns the config values with their placeholders replaced by
+// the resolved values. Only the values of keys are returned, or all if keys
+// is nil.
+func (p secretPlaceholders) interpolate(values map[string]interface{}, keys []string) (map[string]interface{}, error) {
+	replacements := make(map[string]string, len(p.keys))
+	for placeholder, resolverKey := range p.keys {
+		value, ok := values[resolverKey]
+		if !ok {
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			replacements[placeholder] = v
+		case []byte:
+			replacements[placeholder] = string(v)
+		default:
+			return nil, errors.New(fmt.Sprintf("secret placeholder %s must resolve to a string, got %T", placeholder, value))
+		}
+	}
+
+	if keys == nil {
+		for key := range p.values {
+			keys = append(keys, key)
+		}
+	}
+
+	// Optional secrets that do not exist have no value and are replaced by nothing
+	interpolated := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		interpolated[key] = secretPlaceholder.ReplaceAllStringFunc(p.values[key], func(placeholder string) string {
+			return replacements[placeholder]
+		})
+	}
+	return interpolated, nil
+}
+
+// renameErrors names the config keys containing a placeholder, instead of its
+// resolver key, in the secret fetch errors in err.
+func (p secretPlaceholders) renameErrors(err error) error {
+	var fetchErrs koanfgcp.SecretFetchErrors
+	if len(p.secrets) == 0 || !errors.As(err, &fetchErrs) {
+		return err
+	}
+
+	renamed := koanfgcp.SecretFetchErrors{}
+	for _, fetchErr := range fetchErrs {
+		if _, ok := p.secrets[fetchErr.Key]; !ok {
+			renamed = append(renamed, fetchErr)
+			continue
+		}
+		for _, key := range p.configKeys(fetchErr.Key) {
+			e := *fetchErr
+			e.Key = key
+			renamed = append(renamed, &e)
+		}
+	}
+	sort.SliceStable(renamed, func(i, j int) bool { return renamed[i].Key < renamed[j].Key })
+	return renamed
+}
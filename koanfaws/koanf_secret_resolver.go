@@ -0,0 +1,257 @@
+package koanfaws
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/knadh/koanf/maps"
+	"github.com/panjf2000/ants/v2"
+	"github.com/pkg/errors"
+	"strings"
+	"sync"
+)
+
+const awsSecretTag = "awssecret"
+const koanfTag = "koanf"
+const defaultConcurrency = 50
+
+// Config holds the AWS SecretsManager Configuration.
+type Config struct {
+	// Region of the SecretsManager API. Defaults to the region of the
+	// default AWS configuration.
+	Region string
+
+	// Endpoint overrides the SecretsManager API endpoint, e.g. to point the
+	// provider at a local stand-in during tests.
+	Endpoint string
+
+	// The AWS SecretsManager Delim that might be used
+	// delim string
+	Delim string
+
+	//Concurrency is the number of goroutines to use when fetching secrets
+	Concurrency int
+
+	SkipKeys []string
+
+	// Secret is the secret id read by ReadBytes.
+	Secret string
+}
+
+// secretClient is the part of the SecretsManager client used by the provider.
+type secretClient interface {
+	GetSecretValue(ctx context.Context, params *secretsmanager.GetSecretValueInput, optFns ...func(*secretsmanager.Options)) (*secretsmanager.GetSecretValueOutput, error)
+}
+
+// SMConfig implements an AWS SecretsManager provider.
+type SMConfig struct {
+	client secretClient
+	config Config
+	target interface{}
+	cb     func(s string) string
+	ctx    context.Context
+}
+
+// Provider returns an AWS SecretsManager provider.
+func Provider(ctx context.Context, cfg Config, target interface{}, cb func(s string) string) (*SMConfig, error) {
+	// load the default config
+	var opts []func(*config.LoadOptions) error
+	if cfg.Region != "" {
+		opts = append(opts, config.WithRegion(cfg.Region))
+	}
+
+	awsCfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not load aws config")
+	}
+
+	client := secretsmanager.NewFromConfig(awsCfg, func(o *secretsmanager.Options) {
+		if cfg.Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+		}
+	})
+
+	sm := newProvider(cfg, target, cb, client)
+	sm.ctx = ctx
+	return sm, nil
+}
+
+// ProviderWithClient returns an AWS SecretsManager provider
+// using an existing AWS SecretsManager client.
+func ProviderWithClient(cfg Config, cb func(s string) string, client *secretsmanager.Client) *SMConfig {
+	return newProvider(cfg, nil, cb, client)
+}
+
+func newProvider(cfg Config, target interface{}, cb func(s string) string, client secretClient) *SMConfig {
+	// check inputs and set
+	if cfg.Delim == "" {
+		cfg.Delim = "."
+	}
+
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = defaultConcurrency
+	}
+
+	return &SMConfig{client: client, config: cfg, cb: cb, target: target}
+}
+
+// SecretNames returns the koanf key to secret id pairs declared by the
+// awssecret tags of cfg, which must be a pointer to a struct.
+func SecretNames(cfg interface{}) (map[string]string, error) {
+	return validateAndResolve(cfg)
+}
+
+// SecretNames returns the koanf key to secret id pairs declared by the
+// awssecret tags of cfg, which must be a pointer to a struct. It lets
+// Konfig.InitializeConfig collect awssecret instead of gcpsecret tags.
+func (sm *SMConfig) SecretNames(cfg interface{}) (map[string]string, error) {
+	return validateAndResolve(cfg)
+}
+
+// context returns the context passed to Provider, used by the koanf
+// Provider methods that do not take one.
+func (sm *SMConfig) context() context.Context {
+	if sm.ctx == nil {
+		return context.Background()
+	}
+	return sm.ctx
+}
+
+// Resolve fetches the secrets referenced in secrets, a map of koanf key to
+// secret id, and returns their values keyed by koanf key.
+func (sm *SMConfig) Resolve(ctx context.Context, secrets map[string]string) (map[string]interface{}, error) {
+	res, err := sm.getSecrets(ctx, secrets)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]interface{}, len(res))
+	for key, value := range res {
+		values[key] = value
+	}
+	return values, nil
+}
+
+// Read returns the secrets referenced by the awssecret tags of the target.
+func (sm *SMConfig) Read() (map[string]interface{}, error) {
+
+	// check if secretId is provided
+	if sm.target == nil {
+		return nil, errors.New("no secret id  provided")
+	}
+
+	secretsToFetch, err := validateAndResolve(sm.target)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range sm.config.SkipKeys {
+		delete(secretsToFetch, k)
+	}
+
+	res, err := sm.getSecrets(sm.context(), secretsToFetch)
+	if err != nil {
+		return nil, err
+	}
+
+	mp := make(map[string]interface{})
+	for key, value := range res {
+		if sm.cb != nil {
+			key = sm.cb(key)
+		}
+		mp[key] = value
+	}
+
+	return maps.Unflatten(mp, sm.config.Delim), nil
+}
+
+type koanfParams struct {
+	koanfName string
+	awsName   string
+}
+
+func (sm *SMConfig) getSecrets(ctx context.Context, secretsToFetch map[string]string) (map[string]string, error) {
+	var wg sync.WaitGroup
+
+	type errorStruct struct {
+		err  error
+		name string
+	}
+
+	c := make(chan errorStruct, len(secretsToFetch))
+	var mux sync.Mutex
+	res := map[string]string{}
+
+	p, _ := ants.NewPoolWithFunc(sm.config.Concurrency, func(i interface{}) {
+		defer wg.Done()
+		p := i.(koanfParams)
+
+		secret, err := getSecretValue(ctx, sm.client, p.awsName)
+		if err != nil {
+			c <- errorStruct{err, p.awsName}
+			return
+		}
+
+		mux.Lock()
+		defer mux.Unlock()
+		res[p.koanfName] = string(secret)
+	})
+
+	for k, v := range secretsToFetch {
+		wg.Add(1)
+		_ = p.Invoke(koanfParams{koanfName: k, awsName: v})
+	}
+
+	defer p.Release()
+
+	wg.Wait()
+
+	close(c)
+
+	var errs []string
+	for e := range c {
+		errs = append(errs, e.name+": "+e.err.Error())
+	}
+
+	if len(errs) != 0 {
+		return nil, errors.New("Error when fetching secrets: " + strings.Join(errs, ", "))
+	}
+
+	return res, nil
+}
+
+// getSecretValue returns the SecretString of a secret, or its SecretBinary
+// if the secret has no string value.
+func getSecretValue(ctx context.Context, client secretClient, id string) ([]byte, error) {
+	secret, err := client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(id),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if secret.SecretString != nil {
+		return []byte(*secret.SecretString), nil
+	}
+	return secret.SecretBinary, nil
+}
+
+// ReadBytes returns the raw value of the secret configured in
+// Config.Secret, so it can be combined with a koanf parser.
+func (sm *SMConfig) ReadBytes() ([]byte, error) {
+	if sm.config.Secret == "" {
+		return nil, errors.New("no secret provided")
+	}
+
+	secret, err := getSecretValue(sm.context(), sm.client, sm.config.Secret)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read secret "+sm.config.Secret)
+	}
+	return secret, nil
+}
+
+// Watch is not supported by the SecretsManager provider.
+func (sm *SMConfig) Watch(cb func(event interface{}, err error)) error {
+	return errors.New("secretsmanager provider does not support this method")
+}
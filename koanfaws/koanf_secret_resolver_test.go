@@ -0,0 +1,146 @@
+package koanfaws
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/knadh/koanf/parsers/yaml"
+	"github.com/knadh/koanf/v2"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newSecretsManagerServer starts a stand-in for the SecretsManager
+// GetSecretValue API serving the given secrets. []byte values are served
+// as SecretBinary.
+func newSecretsManagerServer(t *testing.T, secrets map[string]interface{}) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "secretsmanager.GetSecretValue", r.Header.Get("X-Amz-Target"))
+
+		var in struct{ SecretId string }
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&in))
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		secret, ok := secrets[in.SecretId]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"__type":  "ResourceNotFoundException",
+				"message": "Secrets Manager can't find the specified secret.",
+			})
+			return
+		}
+
+		out := map[string]interface{}{"Name": in.SecretId, "VersionId": "v1"}
+		switch v := secret.(type) {
+		case []byte:
+			out["SecretBinary"] = base64.StdEncoding.EncodeToString(v)
+		default:
+			out["SecretString"] = v
+		}
+		_ = json.NewEncoder(w).Encode(out)
+	}))
+	t.Cleanup(srv.Close)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_REGION", "us-east-1")
+	return srv
+}
+
+func TestKoanfSecret(t *testing.T) {
+
+	type nested struct {
+		Nested string `koanf:"simple" awssecret:"TEST_SECRET"`
+	}
+
+	type cfg struct {
+		Simple string `koanf:"simple" awssecret:"TEST_SECRET"`
+		Binary string `koanf:"binary" awssecret:"BINARY_SECRET"`
+		Ignore string `koanf:"ignore"`
+		Nested nested `koanf:"nested"`
+	}
+
+	srv := newSecretsManagerServer(t, map[string]interface{}{
+		"TEST_SECRET":   "value",
+		"BINARY_SECRET": []byte("binary"),
+	})
+
+	ctx := context.Background()
+	k := koanf.New(".")
+	p, err := Provider(ctx,
+		Config{Endpoint: srv.URL},
+		&cfg{},
+		func(s string) string { return s })
+	require.NoError(t, err)
+	err = k.Load(p, nil)
+	require.NoError(t, err)
+	require.Equal(t, "value", k.String("simple"))
+	require.Equal(t, "binary", k.String("binary"))
+	require.Equal(t, "value", k.String("nested.simple"))
+	require.False(t, k.Exists("ignore"))
+}
+
+func TestKoanfSecretNotFound(t *testing.T) {
+	type cfg struct {
+		Simple  string `koanf:"simple" awssecret:"TEST_SECRET"`
+		Missing string `koanf:"missing" awssecret:"MISSING_SECRET"`
+		Skipped string `koanf:"skipped" awssecret:"SKIPPED_SECRET"`
+	}
+
+	srv := newSecretsManagerServer(t, map[string]interface{}{"TEST_SECRET": "value"})
+
+	p, err := Provider(context.Background(), Config{Endpoint: srv.URL}, &cfg{}, nil)
+	require.NoError(t, err)
+	_, err = p.Read()
+	require.ErrorContains(t, err, "MISSING_SECRET")
+
+	p, err = Provider(context.Background(), Config{Endpoint: srv.URL, SkipKeys: []string{"missing", "skipped"}}, &cfg{}, nil)
+	require.NoError(t, err)
+	res, err := p.Read()
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"simple": "value"}, res)
+}
+
+func TestKoanfSecretReadBytes(t *testing.T) {
+	srv := newSecretsManagerServer(t, map[string]interface{}{"APP_CONFIG": "simple: value\n"})
+
+	p, err := Provider(context.Background(), Config{Endpoint: srv.URL, Secret: "APP_CONFIG"}, nil, nil)
+	require.NoError(t, err)
+
+	k := koanf.New(".")
+	require.NoError(t, k.Load(p, yaml.Parser()))
+	require.Equal(t, "value", k.String("simple"))
+}
+
+func TestKoanfSecretProviderContext(t *testing.T) {
+	type cfg struct {
+		Simple string `koanf:"simple" awssecret:"TEST_SECRET"`
+	}
+
+	// The server never answers, so requests only end with the Provider ctx
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(done) })
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_REGION", "us-east-1")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	p, err := Provider(ctx, Config{Endpoint: srv.URL, Secret: "APP_CONFIG"}, &cfg{}, nil)
+	require.NoError(t, err)
+
+	start := time.Now()
+	_, err = p.Read()
+	require.Error(t, err)
+	_, err = p.ReadBytes()
+	require.Error(t, err)
+	require.Less(t, time.Since(start), 5*time.Second)
+}
@@ -0,0 +1,47 @@
+package koanfaws
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_validateAndResolve(t *testing.T) {
+	type cfg struct {
+		Simple string `koanf:"simple" awssecret:"aws_simple"`
+		Ignore string `koanf:"ignore"`
+	}
+
+	type cfgNested struct {
+		Simple string `koanf:"outer" awssecret:"arn:aws:secretsmanager:us-east-1:123456789012:secret:outer-a1b2c3"`
+		Nested cfg    `koanf:"nested"`
+		Ignore string `koanf:"ignore"`
+	}
+
+	type cfgNestedWithPointer struct {
+		Simple string `koanf:"outer" awssecret:"outer_aws"`
+		Nested *cfg   `koanf:"nested"`
+	}
+
+	tests := []struct {
+		name    string
+		cfg     interface{}
+		want    map[string]string
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{"simple", &cfg{}, map[string]string{"simple": "aws_simple"}, assert.NoError},
+		{"simple with nested", &cfgNested{}, map[string]string{"nested.simple": "aws_simple", "outer": "arn:aws:secretsmanager:us-east-1:123456789012:secret:outer-a1b2c3"}, assert.NoError},
+		{"simple with nested with pointer", &cfgNestedWithPointer{Nested: &cfg{}}, map[string]string{"nested.simple": "aws_simple", "outer": "outer_aws"}, assert.NoError},
+		{"simple with nested with pointer that is nil", &cfgNestedWithPointer{}, map[string]string{"outer": "outer_aws"}, assert.NoError},
+		{"not a pointer", cfg{}, nil, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateAndResolve(tt.cfg)
+			if !tt.wantErr(t, err, fmt.Sprintf("validateAndResolve(%v)", tt.cfg)) {
+				return
+			}
+			assert.Equalf(t, tt.want, got, "validateAndResolve(%v)", tt.cfg)
+		})
+	}
+}
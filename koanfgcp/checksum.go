@@ -0,0 +1,68 @@
+package koanfgcp
+
+import (
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"hash/crc32"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ChecksumError is returned when the payload of a secret version does not
+// match the CRC32C checksum sent by Secret Manager. It carries the DataLoss
+// gRPC code.
+type ChecksumError struct {
+	Version  string
+	Expected int64
+	Actual   int64
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("payload of secret version %s is corrupted: crc32c is %d, expected %d", e.Version, e.Actual, e.Expected)
+}
+
+func (e *ChecksumError) GRPCStatus() *status.Status {
+	return status.New(codes.DataLoss, e.Error())
+}
+
+func crc32c(data []byte) int64 {
+	return int64(crc32.Checksum(data, crc32cTable))
+}
+
+// verifyChecksum checks the payload of secret against its CRC32C checksum.
+// Payloads without a checksum are accepted.
+func verifyChecksum(secret *secretmanagerpb.AccessSecretVersionResponse) error {
+	if secret.Payload == nil || secret.Payload.DataCrc32C == nil {
+		return nil
+	}
+
+	if actual := crc32c(secret.Payload.Data); actual != *secret.Payload.DataCrc32C {
+		return &ChecksumError{Version: secret.Name, Expected: *secret.Payload.DataCrc32C, Actual: actual}
+	}
+	return nil
+}
+
+// NewSecretPayload returns a payload for data with its CRC32C checksum set,
+// so Secret Manager verifies it on write.
+func NewSecretPayload(data []byte) *secretmanagerpb.SecretPayload {
+	checksum := crc32c(data)
+	return &secretmanagerpb.SecretPayload{Data: data, DataCrc32C: &checksum}
+}
+
+// AddSecretVersion adds a version with a checksummed payload to secret, a
+// resource name of the form projects/PROJECT/secrets/NAME.
+func AddSecretVersion(ctx context.Context, client *secretmanager.Client, secret string, data []byte) (*secretmanagerpb.SecretVersion, error) {
+	version, err := client.AddSecretVersion(ctx, &secretmanagerpb.AddSecretVersionRequest{
+		Parent:  secret,
+		Payload: NewSecretPayload(data),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not add secret version")
+	}
+	return version, nil
+}
@@ -0,0 +1,58 @@
+package koanfgcp
+
+import (
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"context"
+	"github.com/googleapis/gax-go/v2"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"testing"
+)
+
+// corruptingClient flips a bit in every payload after it was checksummed.
+type corruptingClient struct {
+	*fakeSecretClient
+}
+
+func (c corruptingClient) AccessSecretVersion(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+	secret, err := c.fakeSecretClient.AccessSecretVersion(ctx, req, opts...)
+	if err != nil {
+		return nil, err
+	}
+	secret.Payload.Data[0] ^= 1
+	return secret, nil
+}
+
+func TestKoanfSecretChecksum(t *testing.T) {
+	type cfg struct {
+		Simple string `koanf:"simple" gcpsecret:"TEST_SECRET"`
+	}
+
+	client := newFakeSecretClient(map[string]string{"TEST_SECRET": "value"})
+
+	res, err := newProvider(Config{Project: "test"}, &cfg{}, nil, client).Read()
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"simple": "value"}, res)
+
+	_, err = newProvider(Config{Project: "test"}, &cfg{}, nil, corruptingClient{client}).Read()
+	var checksumErr *ChecksumError
+	require.True(t, errors.As(err, &checksumErr))
+	require.Equal(t, "projects/test/secrets/TEST_SECRET/versions/1", checksumErr.Version)
+
+	var fetchErr *SecretFetchError
+	require.True(t, errors.As(err, &fetchErr))
+	require.Equal(t, codes.DataLoss, fetchErr.Code)
+}
+
+func Test_verifyChecksum(t *testing.T) {
+	require.NoError(t, verifyChecksum(&secretmanagerpb.AccessSecretVersionResponse{Payload: &secretmanagerpb.SecretPayload{Data: []byte("no checksum")}}))
+	require.NoError(t, verifyChecksum(&secretmanagerpb.AccessSecretVersionResponse{Payload: NewSecretPayload([]byte("value"))}))
+
+	// Known CRC32C check value of "123456789"
+	require.Equal(t, int64(0xe3069283), *NewSecretPayload([]byte("123456789")).DataCrc32C)
+
+	payload := NewSecretPayload([]byte("value"))
+	payload.Data = []byte("other")
+	require.Error(t, verifyChecksum(&secretmanagerpb.AccessSecretVersionResponse{Payload: payload}))
+}
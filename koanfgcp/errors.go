@@ -0,0 +1,64 @@
+package koanfgcp
+
+import (
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"sort"
+	"strings"
+)
+
+// SecretFetchError describes a secret that could not be fetched for a koanf key.
+type SecretFetchError struct {
+	// Key is the koanf key the secret was fetched for.
+	Key string
+	// Secret is the secret name, or the raw gcpsecret tag if it could not be parsed.
+	Secret string
+	// Project is the project the secret was fetched from.
+	Project string
+	// Code is the gRPC code of the failure, InvalidArgument for invalid
+	// references and Unknown for failures without a code.
+	Code codes.Code
+	Err  error
+}
+
+func newSecretFetchError(key, project string, ref secretRef, err error) *SecretFetchError {
+	if ref.project != "" {
+		project = ref.project
+	}
+	return &SecretFetchError{Key: key, Secret: ref.name, Project: project, Code: statusCode(err), Err: err}
+}
+
+func (e *SecretFetchError) Error() string {
+	return fmt.Sprintf("%s: secret %s in project %s: %s", e.Key, e.Secret, e.Project, e.Err.Error())
+}
+
+func (e *SecretFetchError) Unwrap() error {
+	return e.Err
+}
+
+// SecretFetchErrors collects every failure of a fetch. Use errors.As to
+// retrieve it, or a single *SecretFetchError, from errors returned by the
+// provider.
+type SecretFetchErrors []*SecretFetchError
+
+func (e SecretFetchErrors) Error() string {
+	errs := make([]string, len(e))
+	for i, err := range e {
+		errs[i] = err.Error()
+	}
+	return "Error when fetching secrets: " + strings.Join(errs, ", ")
+}
+
+func (e SecretFetchErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// sorted orders the errors by koanf key.
+func (e SecretFetchErrors) sorted() SecretFetchErrors {
+	sort.Slice(e, func(i, j int) bool { return e[i].Key < e[j].Key })
+	return e
+}
@@ -0,0 +1,70 @@
+package koanfgcp
+
+import (
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"context"
+	"github.com/googleapis/gax-go/v2"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+	"testing"
+)
+
+func TestSecretFetchErrors(t *testing.T) {
+	type cfg struct {
+		Missing string `koanf:"missing" gcpsecret:"MISSING_SECRET"`
+		Denied  string `koanf:"denied" gcpsecret:"shared-infra/DENIED_SECRET"`
+		Invalid string `koanf:"invalid" gcpsecret:"INVALID@"`
+		Simple  string `koanf:"simple" gcpsecret:"TEST_SECRET"`
+	}
+
+	client := newFakeSecretClient(map[string]string{"TEST_SECRET": "value", "DENIED_SECRET": "value"})
+	p := newProvider(Config{Project: "test"}, &cfg{}, nil, &deniedClient{fakeSecretClient: client, denied: "DENIED_SECRET"})
+
+	_, err := p.Read()
+	err = errors.Wrap(err, "could not load gcp config")
+
+	var fetchErrs SecretFetchErrors
+	require.True(t, errors.As(err, &fetchErrs))
+	require.Len(t, fetchErrs, 3)
+
+	require.Equal(t, "denied", fetchErrs[0].Key)
+	require.Equal(t, "DENIED_SECRET", fetchErrs[0].Secret)
+	require.Equal(t, "shared-infra", fetchErrs[0].Project)
+	require.Equal(t, codes.PermissionDenied, fetchErrs[0].Code)
+
+	require.Equal(t, "invalid", fetchErrs[1].Key)
+	require.Equal(t, "INVALID@", fetchErrs[1].Secret)
+	require.Equal(t, codes.InvalidArgument, fetchErrs[1].Code)
+
+	require.Equal(t, "missing", fetchErrs[2].Key)
+	require.Equal(t, "MISSING_SECRET", fetchErrs[2].Secret)
+	require.Equal(t, "test", fetchErrs[2].Project)
+	require.Equal(t, codes.NotFound, fetchErrs[2].Code)
+
+	var fetchErr *SecretFetchError
+	require.True(t, errors.As(err, &fetchErr))
+	require.Equal(t, "denied", fetchErr.Key)
+
+	require.ErrorContains(t, err, "Error when fetching secrets: denied: secret DENIED_SECRET in project shared-infra: rpc error: code = PermissionDenied")
+}
+
+type deniedClient struct {
+	*fakeSecretClient
+	denied string
+}
+
+func (d *deniedClient) AccessSecretVersion(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+	if strings.Contains(req.Name, "/secrets/"+d.denied+"/") {
+		return nil, status.Errorf(codes.PermissionDenied, "permission denied on %s", req.Name)
+	}
+	return d.fakeSecretClient.AccessSecretVersion(ctx, req, opts...)
+}
+
+func TestSecretFetchErrorWrapsRetries(t *testing.T) {
+	err := &SecretFetchError{Key: "k", Secret: "S", Project: "p", Code: codes.Unavailable, Err: attemptsError(status.Error(codes.Unavailable, "down"), 3)}
+	require.EqualError(t, err, "k: secret S in project p: failed after 3 attempts: rpc error: code = Unavailable desc = down")
+	require.Equal(t, codes.Unavailable, statusCode(err))
+}
@@ -4,12 +4,15 @@ import (
 	secretmanager "cloud.google.com/go/secretmanager/apiv1"
 	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
 	"context"
+	"encoding/json"
 	"fmt"
+	"github.com/googleapis/gax-go/v2"
 	"github.com/knadh/koanf/maps"
-	"github.com/panjf2000/ants/v2"
+	"github.com/knadh/koanf/parsers/yaml"
 	"github.com/pkg/errors"
 	"google.golang.org/api/option"
-	"strings"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"sync"
 	"time"
 )
@@ -17,6 +20,7 @@ import (
 const gcpSecretTag = "gcpsecret"
 const koanfTag = "koanf"
 const defaultConcurrency = 50
+const defaultWatchInterval = 3600 * time.Second
 
 // Config holds the AWS SecretsManager Configuration.
 type Config struct {
@@ -33,15 +37,86 @@ type Config struct {
 	Concurrency int
 
 	SkipKeys []string
+
+	// MountDir is a directory where secrets are mounted as files named after
+	// the secret, e.g. /secrets/DB_PASS on Cloud Run or GKE. Mounted secrets
+	// are read from disk and the API is only called for missing files. Only
+	// the latest version of a global secret in Project is read from
+	// MountDir/NAME. Secrets of other projects, pinned versions and regional
+	// secrets are read from MountDir/PROJECT[/locations/REGION]/NAME/VERSION.
+	MountDir string
+
+	// SecretTimeout bounds every Secret Manager request. Zero means no timeout.
+	SecretTimeout time.Duration
+
+	// Deadline bounds fetching all secrets of a Read, Resolve or Watch poll.
+	// Zero means no deadline beyond the one of the context.
+	Deadline time.Duration
+
+	// Retry retries requests failing with transient errors. Disabled by default.
+	Retry Retry
+
+	// QPS limits the Secret Manager requests of the provider per second,
+	// retries included, to stay under the per-project quota. Zero means no
+	// limit. See also SetConcurrencyBudget.
+	QPS float64
+
+	// Burst is the number of requests allowed at once before QPS applies.
+	// Defaults to 1.
+	Burst int
+
+	// Cache stores fetched secrets on disk between runs. It is disabled when nil.
+	Cache *Cache
+
+	// Secret is the secret reference read by ReadBytes, in any form accepted
+	// by the gcpsecret tag.
+	Secret string
+
+	// Versions pins secrets to a version number or alias, keyed by secret
+	// name. It takes precedence over a version given in the gcpsecret tag.
+	Versions map[string]string
+
+	// Region is the location of regional secrets, e.g. europe-west1. They
+	// are read from the regional endpoint, see RegionalEndpoint.
+	Region string
+
+	// Regional reads every secret as a regional secret in Region. Otherwise
+	// only secrets with the regional tag option or a location in their
+	// resource name are regional.
+	Regional bool
+}
+
+// secretClient is the part of the Secret Manager client used by the provider.
+type secretClient interface {
+	AccessSecretVersion(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error)
 }
 
 // SMConfig implements an AWS SecretsManager provider.
 type SMConfig struct {
-	client *secretmanager.Client
-	config Config
-	target interface{}
-	input  *secretmanagerpb.AccessSecretVersionRequest
-	cb     func(s string) string
+	client  secretClient
+	config  Config
+	ctx     context.Context
+	target  interface{}
+	input   *secretmanagerpb.AccessSecretVersionRequest
+	cb      func(s string) string
+	limiter *rateLimiter
+
+	// newClient creates the clients of regional endpoints. When it is nil
+	// client is used for regional secrets too.
+	newClient       func(region string) (secretClient, error)
+	regionalClients map[string]secretClient
+
+	mux      sync.Mutex
+	versions map[string]string
+	stop     chan struct{}
+}
+
+// VersionChange describes a watched secret whose resolved version changed.
+type VersionChange struct {
+	Key        string
+	Secret     string
+	OldVersion string
+	NewVersion string
 }
 
 // Provider returns an AWS SecretsManager provider.
@@ -52,6 +127,30 @@ func Provider(ctx context.Context, cfg Config, target interface{}, cb func(s str
 		return nil, errors.Wrap(err, "could not create secretmanager client")
 	}
 
+	sm := newProvider(cfg, target, cb, client)
+	sm.ctx = ctx
+	sm.newClient = func(region string) (secretClient, error) {
+		return secretmanager.NewClient(ctx,
+			option.WithScopes("https://www.googleapis.com/auth/cloud-platform"),
+			option.WithEndpoint(RegionalEndpoint(region)))
+	}
+	return sm, nil
+}
+
+// RegionalEndpoint returns the Secret Manager endpoint serving the regional
+// secrets of region.
+func RegionalEndpoint(region string) string {
+	return fmt.Sprintf("secretmanager.%s.rep.googleapis.com:443", region)
+}
+
+// ProviderWithClient returns an AWS SecretsManager provider
+// using an existing AWS SecretsManager client. The client is used for
+// regional secrets as well.
+func ProviderWithClient(cfg Config, cb func(s string) string, client *secretmanager.Client) *SMConfig {
+	return newProvider(cfg, nil, cb, client)
+}
+
+func newProvider(cfg Config, target interface{}, cb func(s string) string, client secretClient) *SMConfig {
 	// check inputs and set
 	if cfg.Delim == "" {
 		cfg.Delim = "."
@@ -61,39 +160,48 @@ func Provider(ctx context.Context, cfg Config, target interface{}, cb func(s str
 		cfg.Concurrency = defaultConcurrency
 	}
 
-	return &SMConfig{client: client, config: cfg, cb: cb, target: target}, nil
+	return &SMConfig{client: client, config: cfg, cb: cb, target: target, limiter: newRateLimiter(cfg.QPS, cfg.Burst)}
 }
 
-// ProviderWithClient returns an AWS SecretsManager provider
-// using an existing AWS SecretsManager client.
-func ProviderWithClient(cfg Config, cb func(s string) string, client *secretmanager.Client) *SMConfig {
-	return &SMConfig{client: client, config: cfg, cb: cb}
+// SecretNames returns the koanf key to secret name pairs declared by the
+// gcpsecret tags of cfg, which must be a pointer to a struct.
+func SecretNames(cfg interface{}) (map[string]string, error) {
+	return validateAndResolve(cfg)
+}
+
+// Resolve fetches the secrets referenced in secrets, a map of koanf key to
+// secret name, and returns their values keyed by koanf key.
+func (sm *SMConfig) Resolve(ctx context.Context, secrets map[string]string) (map[string]interface{}, error) {
+	res, err := sm.getSecrets(ctx, secrets, false)
+	if err != nil {
+		return nil, err
+	}
+	sm.recordVersions(secrets, res)
+
+	return secretValues(res)
 }
 
 // Read is not supported by the SecretsManager provider.
 func (sm *SMConfig) Read() (map[string]interface{}, error) {
 
-	// check if secretId is provided
-	if sm.target == nil {
-		return nil, errors.New("no secret id  provided")
-	}
-
-	secretsToFetch, err := validateAndResolve(sm.target)
+	secretsToFetch, err := sm.secretsToFetch()
 	if err != nil {
 		return nil, err
 	}
 
-	for _, k := range sm.config.SkipKeys {
-		delete(secretsToFetch, k)
+	res, err := sm.getSecrets(sm.context(), secretsToFetch, false)
+	if err != nil {
+		return nil, err
 	}
+	sm.recordVersions(secretsToFetch, res)
 
-	res, err := sm.getSecrets(context.TODO(), secretsToFetch)
+	values, err := secretValues(res)
 	if err != nil {
 		return nil, err
 	}
 
 	mp := make(map[string]interface{})
-	for key, value := range res {
+	for key, value := range values {
 		if sm.cb != nil {
 			key = sm.cb(key)
 		}
@@ -103,107 +211,417 @@ func (sm *SMConfig) Read() (map[string]interface{}, error) {
 	return maps.Unflatten(mp, sm.config.Delim), nil
 }
 
+// context returns the context passed to Provider, used by the koanf
+// Provider methods that do not take one.
+func (sm *SMConfig) context() context.Context {
+	if sm.ctx == nil {
+		return context.Background()
+	}
+	return sm.ctx
+}
+
+func (sm *SMConfig) secretsToFetch() (map[string]string, error) {
+	// check if secretId is provided
+	if sm.target == nil {
+		return nil, errors.New("no secret id  provided")
+	}
+
+	secretsToFetch, err := validateAndResolve(sm.target)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range sm.config.SkipKeys {
+		delete(secretsToFetch, k)
+	}
+	return secretsToFetch, nil
+}
+
+// SecretVersions returns the resolved version names of the secrets fetched
+// last, keyed by koanf key.
+func (sm *SMConfig) SecretVersions() map[string]string {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+
+	versions := make(map[string]string, len(sm.versions))
+	for key, version := range sm.versions {
+		versions[key] = version
+	}
+	return versions
+}
+
+// recordVersions stores the resolved version name of every fetched secret and
+// returns the secrets whose version differs from the previously recorded one.
+func (sm *SMConfig) recordVersions(secrets map[string]string, res map[string]fetchedSecret) []VersionChange {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+
+	if sm.versions == nil {
+		sm.versions = make(map[string]string, len(res))
+	}
+
+	var changes []VersionChange
+	for key, secret := range res {
+		old, seen := sm.versions[key]
+		sm.versions[key] = secret.version
+		if seen && old != secret.version {
+			changes = append(changes, VersionChange{Key: key, Secret: secrets[key], OldVersion: old, NewVersion: secret.version})
+		}
+	}
+	return changes
+}
+
 type koanfParams struct {
 	koanfName string
 	gcpName   string
+	ref       secretRef
 }
 
-func (sm *SMConfig) getSecrets(ctx context.Context, secretsToFetch map[string]string) (map[string]string, error) {
-	var wg sync.WaitGroup
+// fetchedSecret is a secret version fetched for a koanf key.
+type fetchedSecret struct {
+	ref     secretRef
+	version string
+	data    []byte
+}
 
-	type errorStruct struct {
-		err  error
-		name string
+// value returns the secret payload as a string, as raw bytes for secrets
+// tagged with the binary option, or as a parsed map for secrets tagged with
+// the json or yaml option.
+func (s fetchedSecret) value() (interface{}, error) {
+	switch s.ref.format {
+	case formatBinary:
+		return s.data, nil
+	case formatJSON:
+		var mp map[string]interface{}
+		if err := json.Unmarshal(s.data, &mp); err != nil {
+			return nil, errors.Wrapf(err, "could not parse secret %s as json", s.ref.name)
+		}
+		return mp, nil
+	case formatYAML:
+		mp, err := yaml.Parser().Unmarshal(s.data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not parse secret %s as yaml", s.ref.name)
+		}
+		return mp, nil
 	}
+	return string(s.data), nil
+}
 
-	c := make(chan errorStruct, len(secretsToFetch))
-	var mux sync.Mutex
-	res := map[string]string{}
+func secretValues(res map[string]fetchedSecret) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(res))
+	for key, secret := range res {
+		value, err := secret.value()
+		if err != nil {
+			return nil, errors.Wrap(err, key)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
 
-	p, _ := ants.NewPoolWithFunc(sm.config.Concurrency, func(i interface{}) {
-		defer wg.Done()
-		p := i.(koanfParams)
+// getSecrets fetches secretsToFetch, a map of koanf key to gcpsecret tag.
+// Cached secrets are fetched again if skipCache is set.
+func (sm *SMConfig) getSecrets(ctx context.Context, secretsToFetch map[string]string, skipCache bool) (map[string]fetchedSecret, error) {
+	if sm.config.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sm.config.Deadline)
+		defer cancel()
+	}
+
+	var wg sync.WaitGroup
 
-		secret, err := getLatestSecretVersion(sm.client, sm.config.Project, p.gcpName)
+	c := make(chan *SecretFetchError, len(secretsToFetch))
+	var mux sync.Mutex
+	res := map[string]fetchedSecret{}
+
+	// Keys referencing the same secret version share one fetch, so they
+	// also see the same version of a secret pinned to an alias or latest
+	plan := map[string][]koanfParams{}
+	for k, v := range secretsToFetch {
+		ref, err := parseSecretRef(v)
 		if err != nil {
-			c <- errorStruct{err, p.gcpName}
-			return
+			c <- &SecretFetchError{Key: k, Secret: v, Project: sm.config.Project, Code: codes.InvalidArgument, Err: err}
+			continue
 		}
+		resolved, err := sm.resolveRef(ref)
+		if err != nil {
+			c <- newSecretFetchError(k, sm.config.Project, ref, err)
+			continue
+		}
+		id := resolved.versionName(sm.config.Project)
+		plan[id] = append(plan[id], koanfParams{koanfName: k, gcpName: v, ref: ref})
+	}
+
+	fetch := func(keys []koanfParams) {
+		defer wg.Done()
+
+		secret, err := sm.fetchSecret(ctx, keys[0].ref, skipCache)
 
 		mux.Lock()
 		defer mux.Unlock()
-		res[p.koanfName] = string(secret.Payload.Data)
-	})
+		for _, p := range keys {
+			if p.ref.optional && statusCode(err) == codes.NotFound {
+				continue
+			}
+			if err != nil {
+				c <- newSecretFetchError(p.koanfName, sm.config.Project, p.ref, err)
+				continue
+			}
+			// Each key parses the payload with the format of its own reference
+			secret.ref = p.ref
+			res[p.koanfName] = secret
+		}
+	}
 
-	for k, v := range secretsToFetch {
+	// Concurrency limits this call, the budget all providers together
+	sem := make(chan struct{}, sm.config.Concurrency)
+	for _, keys := range plan {
+		if err := acquire(ctx, sem); err != nil {
+			// The deadline passed while waiting for other fetches
+			for _, p := range keys {
+				c <- newSecretFetchError(p.koanfName, sm.config.Project, p.ref, err)
+			}
+			continue
+		}
 		wg.Add(1)
-		_ = p.Invoke(koanfParams{koanfName: k, gcpName: v})
+		go func(keys []koanfParams) {
+			defer func() {
+				budget.release()
+				<-sem
+			}()
+			fetch(keys)
+		}(keys)
 	}
 
-	defer p.Release()
-
 	wg.Wait()
 
 	close(c)
 
-	var errs []string
+	var errs SecretFetchErrors
 	for e := range c {
-		errs = append(errs, e.name+": "+e.err.Error())
+		errs = append(errs, e)
 	}
 
 	if len(errs) != 0 {
-		return nil, errors.New("Error when fetching secrets: " + strings.Join(errs, ", "))
+		return nil, errs.sorted()
 	}
 
 	return res, nil
 }
 
-func getLatestSecretVersion(client *secretmanager.Client, project, name string) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+// acquire takes a slot of sem and of the process-wide budget. It fails with
+// a gRPC status error if ctx is done first.
+func acquire(ctx context.Context, sem chan struct{}) error {
+	select {
+	case sem <- struct{}{}:
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	if err := budget.acquire(ctx); err != nil {
+		<-sem
+		return err
+	}
+	return nil
+}
+
+// fetchSecret returns the secret version referenced by ref, read from
+// Config.MountDir if the secret is mounted there, from Config.Cache if it is
+// cached and from the API otherwise.
+func (sm *SMConfig) fetchSecret(ctx context.Context, ref secretRef, skipCache bool) (fetchedSecret, error) {
+	versioned, err := sm.resolveRef(ref)
+	if err != nil {
+		return fetchedSecret{}, err
+	}
+
+	if sm.config.MountDir != "" {
+		secret, ok, err := readMountedSecret(sm.config.MountDir, sm.config.Project, versioned)
+		if err != nil || ok {
+			secret.ref = ref
+			return secret, err
+		}
+	}
+
+	if sm.config.Cache != nil && !skipCache {
+		if secret, ok := sm.config.Cache.get(sm.config.Project, versioned); ok {
+			secret.ref = ref
+			return secret, nil
+		}
+	}
+
+	// Regional endpoints are only dialled when a secret is not served locally
+	client, err := sm.clientFor(versioned.location)
+	if err != nil {
+		return fetchedSecret{}, err
+	}
+
+	var secret *secretmanagerpb.AccessSecretVersionResponse
+	err = sm.config.Retry.do(ctx, func() (err error) {
+		if err := sm.limiter.wait(ctx); err != nil {
+			return err
+		}
+		secret, err = getSecretVersion(ctx, client, sm.config.Project, versioned, sm.config.SecretTimeout)
+		return err
+	})
+	if err != nil {
+		return fetchedSecret{}, err
+	}
+	fetched := fetchedSecret{ref: ref, version: secret.Name, data: secret.Payload.Data}
+
+	if sm.config.Cache != nil {
+		// A cache that cannot be written only costs speed
+		_ = sm.config.Cache.put(sm.config.Project, versioned, fetched)
+	}
+	return fetched, nil
+}
+
+// resolveRef applies the version overrides and region of the Config to ref.
+func (sm *SMConfig) resolveRef(ref secretRef) (secretRef, error) {
+	ref = ref.withVersions(sm.config.Versions)
+	if sm.config.Regional {
+		ref.regional = true
+	}
+	resolved, err := ref.withRegion(sm.config.Region)
+	if err != nil {
+		return secretRef{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return resolved, nil
+}
+
+// clientFor returns the client serving secrets in location, creating the
+// client of a regional endpoint on first use.
+func (sm *SMConfig) clientFor(location string) (secretClient, error) {
+	if location == "" || sm.newClient == nil {
+		return sm.client, nil
+	}
+
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+	if client, ok := sm.regionalClients[location]; ok {
+		return client, nil
+	}
+	client, err := sm.newClient(location)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create secretmanager client for region "+location)
+	}
+	if sm.regionalClients == nil {
+		sm.regionalClients = map[string]secretClient{}
+	}
+	sm.regionalClients[location] = client
+	return client, nil
+}
+
+func getSecretVersion(ctx context.Context, client secretClient, project string, ref secretRef, timeout time.Duration) (*secretmanagerpb.AccessSecretVersionResponse, error) {
 	req := &secretmanagerpb.AccessSecretVersionRequest{
-		Name: fmt.Sprintf("projects/%s/secrets/%s/versions/latest", project, name),
+		Name: ref.versionName(project),
+	}
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
 
-	secret, err := client.AccessSecretVersion(context.TODO(), req)
+	secret, err := client.AccessSecretVersion(ctx, req)
 	if err != nil {
 		return nil, err
 	}
+
+	if err := verifyChecksum(secret); err != nil {
+		return nil, err
+	}
 	return secret, nil
 }
 
-// ReadBytes returns the raw bytes for parsing.
+// ReadBytes returns the raw payload of the secret configured in
+// Config.Secret, so it can be combined with a koanf parser.
 func (sm *SMConfig) ReadBytes() ([]byte, error) {
-	// shoud implement for SecretBinary. maybe in future
-	return nil, errors.New("secretsmanager provider does not support this method")
+	if sm.config.Secret == "" {
+		return nil, errors.New("no secret provided")
+	}
+
+	ref, err := parseSecretRef(sm.config.Secret)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := sm.context()
+	if sm.config.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sm.config.Deadline)
+		defer cancel()
+	}
+
+	secret, err := sm.fetchSecret(ctx, ref, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read secret "+ref.name)
+	}
+	return secret.data, nil
 }
 
-// Watch polls AWS AppConfig for configuration updates.
+// Watch polls Secret Manager every WatchInterval for new versions of the
+// secrets referenced by the target's gcpsecret tags. cb is called with a
+// []VersionChange whenever the resolved version of a secret changes, and
+// with the error if polling fails. Polling continues until Unwatch is called.
+// Polls are bounded by SecretTimeout and Deadline but not by the context
+// passed to Provider. Polls bypass the Cache, refreshing its entries, and
+// secrets read from MountDir change version when their file content changes.
 func (sm *SMConfig) Watch(cb func(event interface{}, err error)) error {
-	//if sm.config.WatchInterval == 0 {
-	//	// Set default watch interval to 3600 seconds. to reduce cost
-	//	sm.config.WatchInterval = 3600 * time.Second
-	//}
-	//
-	//go func() {
-	//loop:
-	//	for {
-	//		conf, err := sm.client.GetSecretValue(context.TODO(), &sm.input)
-	//		if err != nil {
-	//			cb(nil, err)
-	//			break loop
-	//		}
-	//
-	//		// Check if the the configuration has been updated.
-	//		if *conf.VersionId == *sm.config.VersionId {
-	//			// Configuration is not updated and we have the latest version.
-	//			// Sleep for WatchInterval and retry watcher.
-	//			time.Sleep(sm.config.WatchInterval)
-	//			continue
-	//		}
-	//
-	//		// Trigger event.
-	//		cb(nil, nil)
-	//	}
-	//}()
+	secretsToFetch, err := sm.secretsToFetch()
+	if err != nil {
+		return err
+	}
 
+	if sm.config.WatchInterval == 0 {
+		// Set default watch interval to 3600 seconds. to reduce cost
+		sm.config.WatchInterval = defaultWatchInterval
+	}
+
+	sm.mux.Lock()
+	if sm.stop != nil {
+		sm.mux.Unlock()
+		return errors.New("secretmanager provider is already being watched")
+	}
+	stop := make(chan struct{})
+	sm.stop = stop
+	sm.mux.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(sm.config.WatchInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			// The context passed to Provider may only cover startup, so polls use
+			// their own. Polls skip the cache, which would hide new versions
+			res, err := sm.getSecrets(context.Background(), secretsToFetch, true)
+			if err != nil {
+				cb(nil, err)
+				continue
+			}
+
+			if changes := sm.recordVersions(secretsToFetch, res); len(changes) != 0 {
+				cb(changes, nil)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Unwatch stops a watcher started by Watch.
+func (sm *SMConfig) Unwatch() error {
+	sm.mux.Lock()
+	defer sm.mux.Unlock()
+
+	if sm.stop == nil {
+		return errors.New("secretmanager provider is not being watched")
+	}
+	close(sm.stop)
+	sm.stop = nil
 	return nil
 }
@@ -1,10 +1,21 @@
 package koanfgcp
 
 import (
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
 	"context"
+	"fmt"
+	"github.com/googleapis/gax-go/v2"
+	"github.com/knadh/koanf/parsers/yaml"
 	"github.com/knadh/koanf/v2"
+	"github.com/mscno/konfig/koanfgcptest"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestKoanfSecret(t *testing.T) {
@@ -20,14 +31,386 @@ func TestKoanfSecret(t *testing.T) {
 	}
 
 	ctx := context.Background()
+	srv := koanfgcptest.NewServer(map[string]string{"playground-mscno/TEST_SECRET": "secret"})
+	defer srv.Close()
+	client, err := srv.Client(ctx)
+	require.NoError(t, err)
+	defer client.Close()
+
 	k := koanf.New(".")
-	p, err := Provider(ctx,
+	p := newProvider(
 		Config{Project: "playground-mscno"},
 		&cfg{},
-		func(s string) string { return s })
-	require.NoError(t, err)
+		func(s string) string { return s },
+		client)
 	err = k.Load(p, nil)
 	require.NoError(t, err)
 	require.NotEmpty(t, k.String("simple"))
 	require.NotEmpty(t, k.String("nested.simple"))
 }
+
+type fakeSecretClient struct {
+	mux       sync.Mutex
+	secrets   map[string][]string
+	calls     int
+	delay     time.Duration
+	errs      []error
+	active    int
+	maxActive int
+}
+
+func newFakeSecretClient(secrets map[string]string) *fakeSecretClient {
+	f := &fakeSecretClient{secrets: map[string][]string{}}
+	for name, payload := range secrets {
+		f.addVersion(name, payload)
+	}
+	return f
+}
+
+func (f *fakeSecretClient) addVersion(name, payload string) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.secrets[name] = append(f.secrets[name], payload)
+}
+
+func (f *fakeSecretClient) AccessSecretVersion(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest, opts ...gax.CallOption) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+	f.mux.Lock()
+	f.active++
+	if f.active > f.maxActive {
+		f.maxActive = f.active
+	}
+	f.mux.Unlock()
+
+	if f.delay > 0 {
+		select {
+		case <-time.After(f.delay):
+		case <-ctx.Done():
+			f.mux.Lock()
+			f.active--
+			f.mux.Unlock()
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+	}
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.active--
+	f.calls++
+
+	if len(f.errs) != 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return nil, err
+	}
+
+	// projects/<project>/secrets/<name>/versions/<version>
+	parts := strings.Split(req.Name, "/")
+	project, name, version := parts[1], parts[3], parts[5]
+	versions, ok := f.secrets[name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "secret %s not found", name)
+	}
+
+	n := len(versions)
+	if version != "latest" {
+		var err error
+		n, err = strconv.Atoi(version)
+		if err != nil || n < 1 || n > len(versions) {
+			return nil, status.Errorf(codes.NotFound, "version %s of secret %s not found", version, name)
+		}
+	}
+
+	return &secretmanagerpb.AccessSecretVersionResponse{
+		Name:    fmt.Sprintf("projects/%s/secrets/%s/versions/%d", project, name, n),
+		Payload: NewSecretPayload([]byte(versions[n-1])),
+	}, nil
+}
+
+func TestKoanfSecretWatch(t *testing.T) {
+	type cfg struct {
+		Simple string `koanf:"simple" gcpsecret:"TEST_SECRET"`
+		Other  string `koanf:"other" gcpsecret:"OTHER_SECRET"`
+	}
+
+	client := newFakeSecretClient(map[string]string{"TEST_SECRET": "v1", "OTHER_SECRET": "other"})
+	p := newProvider(Config{Project: "test", WatchInterval: 10 * time.Millisecond}, &cfg{}, nil, client)
+
+	k := koanf.New(".")
+	require.NoError(t, k.Load(p, nil))
+	require.Equal(t, "v1", k.String("simple"))
+
+	events := make(chan []VersionChange, 1)
+	require.NoError(t, p.Watch(func(event interface{}, err error) {
+		require.NoError(t, err)
+		events <- event.([]VersionChange)
+	}))
+	defer p.Unwatch()
+
+	client.addVersion("TEST_SECRET", "v2")
+
+	select {
+	case changes := <-events:
+		require.Equal(t, []VersionChange{{
+			Key:        "simple",
+			Secret:     "TEST_SECRET",
+			OldVersion: "projects/test/secrets/TEST_SECRET/versions/1",
+			NewVersion: "projects/test/secrets/TEST_SECRET/versions/2",
+		}}, changes)
+	case <-time.After(time.Second):
+		t.Fatal("no version change reported")
+	}
+
+	require.NoError(t, k.Load(p, nil))
+	require.Equal(t, "v2", k.String("simple"))
+}
+
+func TestKoanfSecretPinnedVersion(t *testing.T) {
+	type cfg struct {
+		Pinned     string `koanf:"pinned" gcpsecret:"TEST_SECRET@1"`
+		Latest     string `koanf:"latest" gcpsecret:"TEST_SECRET"`
+		Overridden string `koanf:"overridden" gcpsecret:"OTHER_SECRET@3"`
+	}
+
+	client := newFakeSecretClient(map[string]string{"TEST_SECRET": "v1", "OTHER_SECRET": "other-v1"})
+	client.addVersion("TEST_SECRET", "v2")
+	client.addVersion("OTHER_SECRET", "other-v2")
+
+	p := newProvider(Config{Project: "test", Versions: map[string]string{"OTHER_SECRET": "2"}}, &cfg{}, nil, client)
+
+	k := koanf.New(".")
+	require.NoError(t, k.Load(p, nil))
+	require.Equal(t, "v1", k.String("pinned"))
+	require.Equal(t, "v2", k.String("latest"))
+	require.Equal(t, "other-v2", k.String("overridden"))
+}
+
+func TestKoanfSecretCrossProject(t *testing.T) {
+	type cfg struct {
+		Local  string `koanf:"local" gcpsecret:"TEST_SECRET"`
+		Short  string `koanf:"short" gcpsecret:"shared-infra/SENTRY_DSN"`
+		Full   string `koanf:"full" gcpsecret:"projects/shared-infra/secrets/SENTRY_DSN/versions/1"`
+		Pinned string `koanf:"pinned" gcpsecret:"shared-infra/SENTRY_DSN@1"`
+	}
+
+	client := newFakeSecretClient(map[string]string{"TEST_SECRET": "local", "SENTRY_DSN": "dsn"})
+	p := newProvider(Config{Project: "test"}, &cfg{}, nil, client)
+	_, err := p.Read()
+	require.NoError(t, err)
+
+	require.Equal(t, map[string]string{
+		"local":  "projects/test/secrets/TEST_SECRET/versions/1",
+		"short":  "projects/shared-infra/secrets/SENTRY_DSN/versions/1",
+		"full":   "projects/shared-infra/secrets/SENTRY_DSN/versions/1",
+		"pinned": "projects/shared-infra/secrets/SENTRY_DSN/versions/1",
+	}, p.SecretVersions())
+}
+
+func TestKoanfSecretDeduplicated(t *testing.T) {
+	client := newFakeSecretClient(map[string]string{"API_KEY": "key", "DB_CREDS": `{"user": "app"}`})
+	p := newProvider(Config{Project: "test"}, nil, nil, client)
+
+	values, err := p.Resolve(context.Background(), map[string]string{
+		"billing.api_key":  "API_KEY",
+		"shipping.api_key": "test/API_KEY",
+		"full":             "projects/test/secrets/API_KEY/versions/latest",
+		"pinned":           "API_KEY@1",
+		"db":               "DB_CREDS,json",
+		"db_raw":           "DB_CREDS",
+		"missing":          "MISSING,optional",
+		"missing_again":    "MISSING,optional",
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"billing.api_key":  "key",
+		"shipping.api_key": "key",
+		"full":             "key",
+		"pinned":           "key",
+		"db":               map[string]interface{}{"user": "app"},
+		"db_raw":           `{"user": "app"}`,
+	}, values)
+	// API_KEY latest, API_KEY@1, DB_CREDS latest and MISSING latest
+	require.Equal(t, 4, client.calls)
+
+	_, err = p.Resolve(context.Background(), map[string]string{"required": "MISSING", "optional": "MISSING,optional"})
+	var fetchErrs SecretFetchErrors
+	require.ErrorAs(t, err, &fetchErrs)
+	require.Len(t, fetchErrs, 1)
+	require.Equal(t, "required", fetchErrs[0].Key)
+}
+
+func TestKoanfSecretRegional(t *testing.T) {
+	ctx := context.Background()
+	srv := koanfgcptest.NewServer(map[string]string{
+		"test/DB_PASS": "global",
+		"projects/test/locations/europe-west1/secrets/DB_PASS":  "eu",
+		"projects/shared/locations/us-east1/secrets/SENTRY_DSN": "us",
+	})
+	defer srv.Close()
+	client, err := srv.Client(ctx)
+	require.NoError(t, err)
+	defer client.Close()
+
+	regions := map[string]int{}
+	newClient := func(region string) (secretClient, error) {
+		regions[region]++
+		return client, nil
+	}
+
+	p := newProvider(Config{Project: "test", Region: "europe-west1"}, nil, nil, client)
+	p.newClient = newClient
+	values, err := p.Resolve(ctx, map[string]string{
+		"global":   "DB_PASS",
+		"regional": "DB_PASS,regional",
+		"again":    "DB_PASS@latest,regional",
+		"located":  "projects/shared/locations/us-east1/secrets/SENTRY_DSN",
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"global": "global", "regional": "eu", "again": "eu", "located": "us"}, values)
+	require.Equal(t, map[string]int{"europe-west1": 1, "us-east1": 1}, regions)
+	require.Equal(t, "projects/test/locations/europe-west1/secrets/DB_PASS/versions/1", p.SecretVersions()["regional"])
+
+	p = newProvider(Config{Project: "test", Region: "europe-west1", Regional: true}, nil, nil, client)
+	values, err = p.Resolve(ctx, map[string]string{"db": "DB_PASS"})
+	require.NoError(t, err)
+	require.Equal(t, "eu", values["db"])
+
+	p = newProvider(Config{Project: "test"}, nil, nil, client)
+	_, err = p.Resolve(ctx, map[string]string{"db": "DB_PASS,regional"})
+	var fetchErrs SecretFetchErrors
+	require.ErrorAs(t, err, &fetchErrs)
+	require.Equal(t, codes.InvalidArgument, fetchErrs[0].Code)
+
+	require.Equal(t, "secretmanager.europe-west1.rep.googleapis.com:443", RegionalEndpoint("europe-west1"))
+}
+
+func TestKoanfSecretStructured(t *testing.T) {
+	type credentials struct {
+		User     string `koanf:"user"`
+		Password string `koanf:"password"`
+		Port     int    `koanf:"port"`
+	}
+
+	type cfg struct {
+		JSON credentials  `koanf:"json" gcpsecret:"DB_CREDS,json"`
+		YAML *credentials `koanf:"yaml" gcpsecret:"DB_CREDS_YAML,yaml"`
+	}
+
+	client := newFakeSecretClient(map[string]string{
+		"DB_CREDS":      `{"user": "app", "password": "secret", "port": 5432}`,
+		"DB_CREDS_YAML": "user: app\npassword: secret\nport: 5432\n",
+	})
+	p := newProvider(Config{Project: "test"}, &cfg{}, nil, client)
+
+	k := koanf.New(".")
+	require.NoError(t, k.Load(p, nil))
+
+	var out cfg
+	require.NoError(t, k.Unmarshal("", &out))
+	want := credentials{User: "app", Password: "secret", Port: 5432}
+	require.Equal(t, want, out.JSON)
+	require.Equal(t, &want, out.YAML)
+}
+
+func TestKoanfSecretStructuredInvalid(t *testing.T) {
+	type cfg struct {
+		JSON struct {
+			User string `koanf:"user"`
+		} `koanf:"json" gcpsecret:"DB_CREDS,json"`
+	}
+
+	client := newFakeSecretClient(map[string]string{"DB_CREDS": "not json"})
+	p := newProvider(Config{Project: "test"}, &cfg{}, nil, client)
+
+	_, err := p.Read()
+	require.ErrorContains(t, err, "could not parse secret DB_CREDS as json")
+}
+
+func TestKoanfSecretBinary(t *testing.T) {
+	type cfg struct {
+		Keystore []byte `koanf:"keystore" gcpsecret:"KEYSTORE"`
+	}
+
+	keystore := string([]byte{0x00, 0xff, 0xfe, 0x01})
+	client := newFakeSecretClient(map[string]string{"KEYSTORE": keystore})
+	p := newProvider(Config{Project: "test"}, &cfg{}, nil, client)
+
+	k := koanf.New(".")
+	require.NoError(t, k.Load(p, nil))
+
+	var out cfg
+	require.NoError(t, k.Unmarshal("", &out))
+	require.Equal(t, []byte(keystore), out.Keystore)
+}
+
+func TestKoanfSecretReadBytes(t *testing.T) {
+	client := newFakeSecretClient(map[string]string{"APP_CONFIG": "simple: value\nnested:\n  simple: nested\n"})
+
+	k := koanf.New(".")
+	p := newProvider(Config{Project: "test", Secret: "APP_CONFIG"}, nil, nil, client)
+	require.NoError(t, k.Load(p, yaml.Parser()))
+	require.Equal(t, "value", k.String("simple"))
+	require.Equal(t, "nested", k.String("nested.simple"))
+
+	_, err := newProvider(Config{Project: "test"}, nil, nil, client).ReadBytes()
+	require.Error(t, err)
+}
+
+func TestKoanfSecretOptional(t *testing.T) {
+	type cfg struct {
+		Simple   string `koanf:"simple" gcpsecret:"TEST_SECRET,optional"`
+		Optional string `koanf:"optional" gcpsecret:"MISSING_SECRET,optional"`
+		Pinned   string `koanf:"pinned" gcpsecret:"TEST_SECRET@5,optional"`
+	}
+
+	client := newFakeSecretClient(map[string]string{"TEST_SECRET": "value"})
+	p := newProvider(Config{Project: "test"}, &cfg{}, nil, client)
+
+	k := koanf.New(".")
+	require.NoError(t, k.Set("optional", "default"))
+	require.NoError(t, k.Load(p, nil))
+	require.Equal(t, "value", k.String("simple"))
+	require.Equal(t, "default", k.String("optional"))
+	require.False(t, k.Exists("pinned"))
+}
+
+func TestKoanfSecretRequiredNotFound(t *testing.T) {
+	type cfg struct {
+		Optional string `koanf:"optional" gcpsecret:"MISSING_SECRET,optional"`
+		Required string `koanf:"required" gcpsecret:"REQUIRED_SECRET"`
+	}
+
+	p := newProvider(Config{Project: "test"}, &cfg{}, nil, newFakeSecretClient(nil))
+	_, err := p.Read()
+	require.ErrorContains(t, err, "REQUIRED_SECRET")
+	require.NotContains(t, err.Error(), "MISSING_SECRET")
+}
+
+func TestKoanfSecretTimeouts(t *testing.T) {
+	type cfg struct {
+		Simple string `koanf:"simple" gcpsecret:"TEST_SECRET"`
+	}
+
+	client := newFakeSecretClient(map[string]string{"TEST_SECRET": "value"})
+	client.delay = time.Minute
+
+	p := newProvider(Config{Project: "test", SecretTimeout: 10 * time.Millisecond}, &cfg{}, nil, client)
+	_, err := p.Read()
+	require.ErrorContains(t, err, "DeadlineExceeded")
+
+	p = newProvider(Config{Project: "test", Deadline: 10 * time.Millisecond}, &cfg{}, nil, client)
+	_, err = p.Resolve(context.Background(), map[string]string{"simple": "TEST_SECRET"})
+	require.ErrorContains(t, err, "DeadlineExceeded")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p = newProvider(Config{Project: "test"}, &cfg{}, nil, client)
+	p.ctx = ctx
+	_, err = p.Read()
+	require.ErrorContains(t, err, "Canceled")
+
+	client.delay = time.Millisecond
+	p = newProvider(Config{Project: "test", SecretTimeout: time.Second, Deadline: time.Second}, &cfg{}, nil, client)
+	res, err := p.Read()
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"simple": "value"}, res)
+}
@@ -0,0 +1,262 @@
+package koanfgcp
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"github.com/knadh/koanf/parsers/yaml"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	yamlv3 "gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const localSecretsKeySize = 32
+const localSecretsVersion = "local"
+const localSecretsProject = "local"
+
+// LocalSecrets resolves gcpsecret references from an encrypted secrets file,
+// so developers can work offline and without IAM grants on Secret Manager.
+//
+// The file is YAML mapping secret names to values encrypted with AES-256-GCM,
+// using the secret name as additional data. The key file holds the base64
+// encoded 32 byte key.
+type LocalSecrets struct {
+	secrets map[string][]byte
+}
+
+// LoadLocalSecrets decrypts the secrets file at path with the key in keyPath.
+func LoadLocalSecrets(path, keyPath string) (*LocalSecrets, error) {
+	secrets, err := readLocalSecrets(path, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalSecrets{secrets: secrets}, nil
+}
+
+// Resolve returns the values of the secrets referenced in secrets, a map of
+// koanf key to gcpsecret reference, keyed by koanf key. Versions and projects
+// in the references are ignored, secrets are looked up by name only.
+func (l *LocalSecrets) Resolve(ctx context.Context, secrets map[string]string) (map[string]interface{}, error) {
+	res := make(map[string]fetchedSecret, len(secrets))
+	var errs SecretFetchErrors
+	for key, tag := range secrets {
+		ref, err := parseSecretRef(tag)
+		if err != nil {
+			errs = append(errs, &SecretFetchError{Key: key, Secret: tag, Project: localSecretsProject, Code: codes.InvalidArgument, Err: err})
+			continue
+		}
+
+		data, ok := l.secrets[ref.name]
+		if !ok && ref.optional {
+			continue
+		}
+		if !ok {
+			errs = append(errs, &SecretFetchError{Key: key, Secret: ref.name, Project: localSecretsProject, Code: codes.NotFound, Err: errors.New("secret not found in local secrets file")})
+			continue
+		}
+		res[key] = fetchedSecret{ref: ref, version: localSecretsVersion, data: data}
+	}
+
+	if len(errs) != 0 {
+		return nil, errs.sorted()
+	}
+
+	return secretValues(res)
+}
+
+// GenerateLocalSecretsKey writes a new random key to keyPath. It does not
+// overwrite an existing key.
+func GenerateLocalSecretsKey(keyPath string) error {
+	key := make([]byte, localSecretsKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return errors.Wrap(err, "could not generate key")
+	}
+
+	f, err := os.OpenFile(keyPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return errors.Wrap(err, "could not create key file")
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(base64.StdEncoding.EncodeToString(key) + "\n")
+	return err
+}
+
+// WriteLocalSecrets encrypts secrets, a map of secret name to value, with the
+// key in keyPath and writes them to the secrets file at path.
+func WriteLocalSecrets(path, keyPath string, secrets map[string]string) error {
+	aead, err := localSecretsCipher(keyPath)
+	if err != nil {
+		return err
+	}
+
+	encrypted := make(map[string]interface{}, len(secrets))
+	for name, value := range secrets {
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return errors.Wrap(err, "could not generate nonce")
+		}
+		sealed := aead.Seal(nonce, nonce, []byte(value), []byte(name))
+		encrypted[name] = base64.StdEncoding.EncodeToString(sealed)
+	}
+
+	b, err := yaml.Parser().Marshal(encrypted)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o600)
+}
+
+// EditLocalSecrets decrypts the secrets file at path into a temporary YAML
+// file, opens it in editor, or $EDITOR if editor is empty, and encrypts the
+// result back into path. A missing secrets file is created.
+func EditLocalSecrets(path, keyPath, editor string) error {
+	secrets := map[string][]byte{}
+	if _, err := os.Stat(path); err == nil {
+		secrets, err = readLocalSecrets(path, keyPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	plain := make(map[string]interface{}, len(secrets))
+	for name, value := range secrets {
+		plain[name] = string(value)
+	}
+	b, err := yaml.Parser().Marshal(plain)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp("", "konfig-secrets-*.yaml")
+	if err != nil {
+		return errors.Wrap(err, "could not create temporary file")
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(b)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not write temporary file")
+	}
+
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	args := append(strings.Fields(editor), tmp.Name())
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return errors.Wrap(err, "editor failed")
+	}
+
+	b, err = os.ReadFile(tmp.Name())
+	if err != nil {
+		return errors.Wrap(err, "could not read temporary file")
+	}
+	edited, err := parseEditedSecrets(b)
+	if err != nil {
+		return errors.Wrap(err, "could not parse edited secrets")
+	}
+	return WriteLocalSecrets(path, keyPath, edited)
+}
+
+// parseEditedSecrets parses a YAML map of secret names to values, keeping the
+// text of every value as written. Values are not resolved to YAML types, which
+// would turn 0123 into 83 or 1e10 into 1e+10.
+func parseEditedSecrets(b []byte) (map[string]string, error) {
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+
+	secrets := map[string]string{}
+	if len(doc.Content) == 0 {
+		return secrets, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yamlv3.MappingNode {
+		return nil, errors.New("secrets must be a map of secret names to values")
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		name, value := root.Content[i].Value, root.Content[i+1]
+		if value.Kind != yamlv3.ScalarNode {
+			return nil, errors.New(fmt.Sprintf("secret %s must be a string", name))
+		}
+		if value.Tag == "!!null" && value.Style == 0 {
+			return nil, errors.New(fmt.Sprintf("secret %s has no value - quote it as \"\" for an empty secret", name))
+		}
+		secrets[name] = value.Value
+	}
+	return secrets, nil
+}
+
+func readLocalSecrets(path, keyPath string) (map[string][]byte, error) {
+	aead, err := localSecretsCipher(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read local secrets file")
+	}
+
+	encrypted, err := yaml.Parser().Unmarshal(b)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not parse local secrets file")
+	}
+
+	secrets := make(map[string][]byte, len(encrypted))
+	for name, value := range encrypted {
+		s, ok := value.(string)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("local secret %s is not an encrypted value", name))
+		}
+
+		sealed, err := base64.StdEncoding.DecodeString(s)
+		if err != nil || len(sealed) < aead.NonceSize() {
+			return nil, errors.New(fmt.Sprintf("local secret %s is not an encrypted value", name))
+		}
+
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		data, err := aead.Open(nil, nonce, ciphertext, []byte(name))
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("could not decrypt local secret %s - check the key file", name))
+		}
+		secrets[name] = data
+	}
+	return secrets, nil
+}
+
+func localSecretsCipher(keyPath string) (cipher.AEAD, error) {
+	b, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not read key file")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(key) != localSecretsKeySize {
+		return nil, errors.New(fmt.Sprintf("key file %s must hold a base64 encoded %d byte key", keyPath, localSecretsKeySize))
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
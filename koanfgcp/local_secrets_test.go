@@ -0,0 +1,122 @@
+package koanfgcp
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalSecrets(t *testing.T) {
+	dir := t.TempDir()
+	path, keyPath := filepath.Join(dir, "secrets.enc.yaml"), filepath.Join(dir, "secrets.key")
+
+	require.NoError(t, GenerateLocalSecretsKey(keyPath))
+	require.Error(t, GenerateLocalSecretsKey(keyPath), "existing keys must not be overwritten")
+	info, err := os.Stat(keyPath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	require.NoError(t, WriteLocalSecrets(path, keyPath, map[string]string{
+		"DB_PASS":  "secret",
+		"DB_CREDS": `{"user": "app"}`,
+	}))
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NotContains(t, string(b), "secret")
+	require.Contains(t, string(b), "DB_PASS")
+
+	l, err := LoadLocalSecrets(path, keyPath)
+	require.NoError(t, err)
+
+	res, err := l.Resolve(context.Background(), map[string]string{
+		"password":    "DB_PASS@3",
+		"credentials": "DB_CREDS,json",
+		"keystore":    "DB_PASS,binary",
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"password":    "secret",
+		"credentials": map[string]interface{}{"user": "app"},
+		"keystore":    []byte("secret"),
+	}, res)
+
+	res, err = l.Resolve(context.Background(), map[string]string{"missing": "MISSING,optional"})
+	require.NoError(t, err)
+	require.Empty(t, res)
+
+	_, err = l.Resolve(context.Background(), map[string]string{"missing": "MISSING"})
+	require.ErrorContains(t, err, "missing: secret MISSING in project local: secret not found in local secrets file")
+}
+
+func TestLocalSecretsWrongKey(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "secrets.enc.yaml")
+	keyPath, otherKeyPath := filepath.Join(dir, "secrets.key"), filepath.Join(dir, "other.key")
+
+	require.NoError(t, GenerateLocalSecretsKey(keyPath))
+	require.NoError(t, GenerateLocalSecretsKey(otherKeyPath))
+	require.NoError(t, WriteLocalSecrets(path, keyPath, map[string]string{"DB_PASS": "secret"}))
+
+	_, err := LoadLocalSecrets(path, otherKeyPath)
+	require.ErrorContains(t, err, "could not decrypt local secret DB_PASS")
+
+	require.NoError(t, os.WriteFile(otherKeyPath, []byte("short"), 0o600))
+	_, err = LoadLocalSecrets(path, otherKeyPath)
+	require.ErrorContains(t, err, "must hold a base64 encoded 32 byte key")
+}
+
+func TestEditLocalSecrets(t *testing.T) {
+	dir := t.TempDir()
+	path, keyPath := filepath.Join(dir, "secrets.enc.yaml"), filepath.Join(dir, "secrets.key")
+	require.NoError(t, GenerateLocalSecretsKey(keyPath))
+
+	editor := filepath.Join(dir, "editor.sh")
+	require.NoError(t, os.WriteFile(editor, []byte("#!/bin/sh\necho 'API_KEY: added' >> \"$1\"\n"), 0o700))
+
+	require.NoError(t, EditLocalSecrets(path, keyPath, editor))
+	require.NoError(t, WriteLocalSecrets(path, keyPath, map[string]string{"DB_PASS": "secret"}))
+	require.NoError(t, EditLocalSecrets(path, keyPath, editor))
+
+	l, err := LoadLocalSecrets(path, keyPath)
+	require.NoError(t, err)
+	require.Equal(t, map[string][]byte{"DB_PASS": []byte("secret"), "API_KEY": []byte("added")}, l.secrets)
+}
+
+func TestEditLocalSecretsRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path, keyPath := filepath.Join(dir, "secrets.enc.yaml"), filepath.Join(dir, "secrets.key")
+	require.NoError(t, GenerateLocalSecretsKey(keyPath))
+	require.NoError(t, WriteLocalSecrets(path, keyPath, map[string]string{"QUOTED": "0456", "MULTILINE": "a\nb"}))
+
+	appendingEditor := func(name, lines string) string {
+		editor := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(editor, []byte("#!/bin/sh\ncat >> \"$1\" <<'EOF'\n"+lines+"EOF\n"), 0o700))
+		return editor
+	}
+
+	editor := appendingEditor("typed.sh", "PIN: 0123\nBIG: 1e10\nFLAG: yes\nEMPTY: \"\"\n")
+	require.NoError(t, EditLocalSecrets(path, keyPath, editor))
+
+	l, err := LoadLocalSecrets(path, keyPath)
+	require.NoError(t, err)
+	require.Equal(t, map[string][]byte{
+		"QUOTED":    []byte("0456"),
+		"MULTILINE": []byte("a\nb"),
+		"PIN":       []byte("0123"),
+		"BIG":       []byte("1e10"),
+		"FLAG":      []byte("yes"),
+		"EMPTY":     nil,
+	}, l.secrets)
+
+	err = EditLocalSecrets(path, keyPath, appendingEditor("nested.sh", "NESTED:\n  user: app\n"))
+	require.ErrorContains(t, err, "secret NESTED must be a string")
+
+	err = EditLocalSecrets(path, keyPath, appendingEditor("null.sh", "UNSET:\n"))
+	require.ErrorContains(t, err, "secret UNSET has no value")
+
+	l, err = LoadLocalSecrets(path, keyPath)
+	require.NoError(t, err)
+	require.Len(t, l.secrets, 6, "rejected edits must not change the secrets file")
+}
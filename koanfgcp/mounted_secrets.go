@@ -0,0 +1,51 @@
+package koanfgcp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+)
+
+// readMountedSecret reads the secret referenced by ref, after version
+// overrides and region are applied, from its file in dir. It returns false if
+// there is no such file. See mountedSecretPath for the file names.
+func readMountedSecret(dir, project string, ref secretRef) (fetchedSecret, bool, error) {
+	path := mountedSecretPath(dir, project, ref)
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fetchedSecret{}, false, nil
+	}
+	if err != nil {
+		return fetchedSecret{}, false, errors.Wrap(err, "could not read mounted secret")
+	}
+
+	// The mounted version is not known, so the file path and a digest of its
+	// content stand in for it, letting rotated files be told apart
+	digest := sha256.Sum256(data)
+	version := "file://" + path + "#sha256:" + hex.EncodeToString(digest[:])
+	return fetchedSecret{ref: ref, version: version, data: data}, true, nil
+}
+
+// mountedSecretPath returns the file of ref in dir. The latest version of a
+// global secret in the provider's own project is mounted as dir/NAME, as
+// Cloud Run and GKE mount secrets. A secret of another project, a pinned
+// version or a regional secret is only read from
+// dir/PROJECT[/locations/REGION]/NAME/VERSION, so that it cannot be confused
+// with a different secret of the same name.
+func mountedSecretPath(dir, project string, ref secretRef) string {
+	own := ref.project == "" || ref.project == project
+	if own && ref.version == latestVersion && ref.location == "" {
+		return filepath.Join(dir, ref.name)
+	}
+
+	if ref.project != "" {
+		project = ref.project
+	}
+	if ref.location != "" {
+		project = filepath.Join(project, "locations", ref.location)
+	}
+	return filepath.Join(dir, project, ref.name, ref.version)
+}
@@ -0,0 +1,124 @@
+package koanfgcp
+
+import (
+	"context"
+	"github.com/knadh/koanf/v2"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKoanfSecretMountDir(t *testing.T) {
+	type cfg struct {
+		Mounted  string `koanf:"mounted" gcpsecret:"MOUNTED_SECRET"`
+		Fallback string `koanf:"fallback" gcpsecret:"TEST_SECRET"`
+	}
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "MOUNTED_SECRET"), []byte("from file"), 0o600))
+
+	client := newFakeSecretClient(map[string]string{"TEST_SECRET": "from api"})
+	p := newProvider(Config{Project: "test", MountDir: dir}, &cfg{}, nil, client)
+
+	k := koanf.New(".")
+	require.NoError(t, k.Load(p, nil))
+	require.Equal(t, "from file", k.String("mounted"))
+	require.Equal(t, "from api", k.String("fallback"))
+	require.True(t, strings.HasPrefix(p.versions["mounted"], "file://"+filepath.Join(dir, "MOUNTED_SECRET")+"#sha256:"))
+}
+
+func TestKoanfSecretWatchMountDir(t *testing.T) {
+	type cfg struct {
+		Mounted string `koanf:"mounted" gcpsecret:"MOUNTED_SECRET"`
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "MOUNTED_SECRET")
+	require.NoError(t, os.WriteFile(path, []byte("v1"), 0o600))
+
+	p := newProvider(Config{Project: "test", MountDir: dir, WatchInterval: 10 * time.Millisecond}, &cfg{}, nil, newFakeSecretClient(nil))
+	k := koanf.New(".")
+	require.NoError(t, k.Load(p, nil))
+	oldVersion := p.versions["mounted"]
+
+	events := make(chan []VersionChange, 1)
+	require.NoError(t, p.Watch(func(event interface{}, err error) {
+		require.NoError(t, err)
+		events <- event.([]VersionChange)
+	}))
+	defer p.Unwatch()
+
+	// Mounted secrets are rotated by replacing the file content
+	require.NoError(t, os.WriteFile(path, []byte("v2"), 0o600))
+
+	select {
+	case changes := <-events:
+		require.Len(t, changes, 1)
+		require.Equal(t, "mounted", changes[0].Key)
+		require.Equal(t, oldVersion, changes[0].OldVersion)
+		require.NotEqual(t, oldVersion, changes[0].NewVersion)
+	case <-time.After(time.Second):
+		t.Fatal("no version change reported")
+	}
+}
+
+func Test_readMountedSecret(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "DIRECTORY"), 0o700))
+
+	_, ok, err := readMountedSecret(dir, "test", secretRef{name: "MISSING", version: latestVersion})
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	_, _, err = readMountedSecret(dir, "test", secretRef{name: "DIRECTORY", version: latestVersion})
+	require.Error(t, err)
+}
+
+func TestKoanfSecretMountDirOnlyOwnLatest(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "DB_PASS"), []byte("mounted latest"), 0o600))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "test", "DB_PASS"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "test", "DB_PASS", "1"), []byte("mounted v1"), 0o600))
+
+	client := newFakeSecretClient(map[string]string{"DB_PASS": "api v1"})
+	client.addVersion("DB_PASS", "api v2")
+	p := newProvider(Config{Project: "test", MountDir: dir}, nil, nil, client)
+
+	values, err := p.Resolve(context.Background(), map[string]string{
+		"latest":        "DB_PASS",
+		"own_project":   "test/DB_PASS",
+		"pinned":        "DB_PASS@1",
+		"other_pinned":  "DB_PASS@2",
+		"other_project": "shared-infra/DB_PASS",
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"latest":        "mounted latest",
+		"own_project":   "mounted latest",
+		"pinned":        "mounted v1",
+		"other_pinned":  "api v2",
+		"other_project": "api v2",
+	}, values)
+}
+
+func Test_mountedSecretPath(t *testing.T) {
+	tests := []struct {
+		name string
+		ref  secretRef
+		want string
+	}{
+		{"own latest", secretRef{name: "DB_PASS", version: "latest"}, "/secrets/DB_PASS"},
+		{"own project named", secretRef{project: "test", name: "DB_PASS", version: "latest"}, "/secrets/DB_PASS"},
+		{"pinned", secretRef{name: "DB_PASS", version: "7"}, "/secrets/test/DB_PASS/7"},
+		{"other project", secretRef{project: "shared", name: "DB_PASS", version: "latest"}, "/secrets/shared/DB_PASS/latest"},
+		{"regional", secretRef{location: "europe-west1", name: "DB_PASS", version: "latest", regional: true}, "/secrets/test/locations/europe-west1/DB_PASS/latest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, mountedSecretPath("/secrets", "test", tt.ref))
+		})
+	}
+}
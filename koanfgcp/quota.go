@@ -0,0 +1,132 @@
+package koanfgcp
+
+import (
+	"context"
+	"google.golang.org/grpc/status"
+	"math"
+	"sync"
+	"time"
+)
+
+const defaultConcurrencyBudget = 100
+
+// budget bounds how many secrets all providers in the process fetch at the
+// same time in total.
+var budget = newConcurrencyBudget(defaultConcurrencyBudget)
+
+// SetConcurrencyBudget sets how many secrets all providers in the process
+// fetch at the same time together. It defaults to 100. Config.Concurrency
+// further limits a single Read or Resolve.
+func SetConcurrencyBudget(n int) {
+	budget.resize(n)
+}
+
+// concurrencyBudget is a counting semaphore that can be resized while in use.
+// Waiters are woken by closing wake whenever a slot may have become free.
+type concurrencyBudget struct {
+	mux  sync.Mutex
+	size int
+	used int
+	wake chan struct{}
+}
+
+func newConcurrencyBudget(size int) *concurrencyBudget {
+	return &concurrencyBudget{size: size, wake: make(chan struct{})}
+}
+
+func (b *concurrencyBudget) resize(size int) {
+	if size < 1 {
+		size = 1
+	}
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.size = size
+	b.notify()
+}
+
+// acquire blocks until a slot is free. It fails with a gRPC status error if
+// ctx is done first.
+func (b *concurrencyBudget) acquire(ctx context.Context) error {
+	for {
+		b.mux.Lock()
+		if b.used < b.size {
+			b.used++
+			b.mux.Unlock()
+			return nil
+		}
+		wake := b.wake
+		b.mux.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+func (b *concurrencyBudget) release() {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.used--
+	b.notify()
+}
+
+func (b *concurrencyBudget) notify() {
+	close(b.wake)
+	b.wake = make(chan struct{})
+}
+
+// rateLimiter is a token bucket that allows qps requests per second on
+// average and bursts of up to burst requests.
+type rateLimiter struct {
+	mux    sync.Mutex
+	qps    float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns nil, which never waits, if qps is not positive.
+func newRateLimiter(qps float64, burst int) *rateLimiter {
+	if qps <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{qps: qps, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// wait blocks until a request may be made. It fails with a gRPC status
+// error if ctx is done first.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mux.Lock()
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.qps)
+	l.last = now
+	l.tokens--
+	delay := time.Duration(-l.tokens / l.qps * float64(time.Second))
+	l.mux.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// Give the reserved token back for the requests still waiting
+		l.mux.Lock()
+		l.tokens++
+		l.mux.Unlock()
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
@@ -0,0 +1,108 @@
+package koanfgcp
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	ctx := context.Background()
+
+	var unlimited *rateLimiter
+	require.NoError(t, unlimited.wait(ctx))
+	require.Nil(t, newRateLimiter(0, 10))
+
+	l := newRateLimiter(100, 2)
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		require.NoError(t, l.wait(ctx))
+	}
+	// Two requests are allowed at once, the other four wait 10ms each
+	require.GreaterOrEqual(t, time.Since(start), 35*time.Millisecond)
+
+	l = newRateLimiter(1, 1)
+	require.NoError(t, l.wait(ctx))
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	require.Equal(t, codes.DeadlineExceeded, statusCode(l.wait(ctx)))
+}
+
+func TestKoanfSecretQPS(t *testing.T) {
+	secrets := map[string]string{}
+	toFetch := map[string]string{}
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("SECRET_%d", i)
+		secrets[name] = "value"
+		toFetch[name] = name
+	}
+
+	client := newFakeSecretClient(secrets)
+	p := newProvider(Config{Project: "playground-mscno", QPS: 50}, nil, nil, client)
+
+	start := time.Now()
+	values, err := p.Resolve(context.Background(), toFetch)
+	require.NoError(t, err)
+	require.Len(t, values, 5)
+	require.GreaterOrEqual(t, time.Since(start), 75*time.Millisecond)
+}
+
+func TestConcurrencyBudget(t *testing.T) {
+	SetConcurrencyBudget(3)
+	t.Cleanup(func() { SetConcurrencyBudget(defaultConcurrencyBudget) })
+
+	secrets := map[string]string{}
+	toFetch := map[string]string{}
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("SECRET_%d", i)
+		secrets[name] = "value"
+		toFetch[name] = name
+	}
+
+	// Both providers share one client to observe their combined concurrency
+	client := newFakeSecretClient(secrets)
+	client.delay = 10 * time.Millisecond
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p := newProvider(Config{Project: "playground-mscno", Concurrency: 10}, nil, nil, client)
+			_, err := p.Resolve(context.Background(), toFetch)
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	require.Equal(t, 20, client.calls)
+	require.LessOrEqual(t, client.maxActive, 3)
+}
+
+func TestConcurrencyBudgetDeadline(t *testing.T) {
+	SetConcurrencyBudget(1)
+	t.Cleanup(func() { SetConcurrencyBudget(defaultConcurrencyBudget) })
+
+	// Another provider holds the whole budget
+	require.NoError(t, budget.acquire(context.Background()))
+	defer budget.release()
+
+	client := newFakeSecretClient(map[string]string{"DB_PASS": "value"})
+	p := newProvider(Config{Project: "playground-mscno", Deadline: 20 * time.Millisecond}, nil, nil, client)
+
+	start := time.Now()
+	_, err := p.Resolve(context.Background(), map[string]string{"db_pass": "DB_PASS", "again": "DB_PASS"})
+	require.Less(t, time.Since(start), time.Second)
+
+	var fetchErrs SecretFetchErrors
+	require.ErrorAs(t, err, &fetchErrs)
+	require.Len(t, fetchErrs, 2)
+	for _, fetchErr := range fetchErrs {
+		require.Equal(t, codes.DeadlineExceeded, fetchErr.Code)
+	}
+	require.Equal(t, 0, client.calls)
+}
@@ -0,0 +1,89 @@
+package koanfgcp
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math/rand"
+	"time"
+)
+
+const defaultInitialBackoff = 100 * time.Millisecond
+const defaultMaxBackoff = 5 * time.Second
+
+// Retry configures retrying of Secret Manager requests that fail with
+// Unavailable, DeadlineExceeded or ResourceExhausted. Backoff starts at
+// InitialBackoff, doubles after every attempt up to MaxBackoff and is
+// jittered.
+type Retry struct {
+	// MaxAttempts is the maximum number of attempts per secret, including
+	// the first one. Zero or one disables retries.
+	MaxAttempts int
+
+	// InitialBackoff defaults to 100ms.
+	InitialBackoff time.Duration
+
+	// MaxBackoff defaults to 5s.
+	MaxBackoff time.Duration
+}
+
+// do calls fn until it succeeds, fails with a non retryable error, the
+// attempts are exhausted or ctx is done. Errors after more than one attempt
+// report the attempt count.
+func (r Retry) do(ctx context.Context, fn func() error) error {
+	backoff := r.InitialBackoff
+	if backoff <= 0 {
+		backoff = defaultInitialBackoff
+	}
+	maxBackoff := r.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultMaxBackoff
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if attempt >= r.MaxAttempts || !isRetryable(err) {
+			return attemptsError(err, attempt)
+		}
+
+		// Full jitter keeps concurrent cold starts from retrying in lockstep
+		select {
+		case <-time.After(time.Duration(rand.Int63n(int64(backoff)) + 1)):
+		case <-ctx.Done():
+			return attemptsError(err, attempt)
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func attemptsError(err error, attempts int) error {
+	if attempts == 1 {
+		return err
+	}
+	return errors.Wrapf(err, "failed after %d attempts", attempts)
+}
+
+func isRetryable(err error) bool {
+	switch statusCode(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
+
+// statusCode returns the gRPC code of err, looking through wrapped errors.
+func statusCode(err error) codes.Code {
+	var se interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &se) {
+		return se.GRPCStatus().Code()
+	}
+	return status.Code(err)
+}
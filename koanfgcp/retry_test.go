@@ -0,0 +1,94 @@
+package koanfgcp
+
+import (
+	"context"
+	"github.com/knadh/koanf/v2"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	r := Retry{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+
+	tests := []struct {
+		name     string
+		errs     []error
+		attempts int
+		wantErr  string
+	}{
+		{"success", nil, 1, ""},
+		{"transient", []error{status.Error(codes.Unavailable, "unavailable"), status.Error(codes.ResourceExhausted, "quota")}, 3, ""},
+		{"exhausted", []error{status.Error(codes.Unavailable, "a"), status.Error(codes.DeadlineExceeded, "b"), status.Error(codes.Unavailable, "c")}, 3, "failed after 3 attempts: rpc error: code = Unavailable desc = c"},
+		{"not retryable", []error{status.Error(codes.PermissionDenied, "denied")}, 1, "rpc error: code = PermissionDenied desc = denied"},
+		{"not retryable after retry", []error{status.Error(codes.Unavailable, "a"), status.Error(codes.NotFound, "missing")}, 2, "failed after 2 attempts: rpc error: code = NotFound desc = missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := r.do(context.Background(), func() error {
+				attempts++
+				if attempts <= len(tt.errs) {
+					return tt.errs[attempts-1]
+				}
+				return nil
+			})
+			require.Equal(t, tt.attempts, attempts)
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestRetryDisabled(t *testing.T) {
+	attempts := 0
+	err := Retry{}.do(context.Background(), func() error {
+		attempts++
+		return status.Error(codes.Unavailable, "unavailable")
+	})
+	require.Error(t, err)
+	require.Equal(t, 1, attempts)
+}
+
+func TestRetryContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	err := Retry{MaxAttempts: 10, InitialBackoff: time.Hour}.do(ctx, func() error {
+		attempts++
+		cancel()
+		return status.Error(codes.Unavailable, "unavailable")
+	})
+	require.Error(t, err)
+	require.Equal(t, 1, attempts)
+}
+
+func Test_statusCode(t *testing.T) {
+	err := status.Error(codes.NotFound, "missing")
+	require.Equal(t, codes.NotFound, statusCode(err))
+	require.Equal(t, codes.NotFound, statusCode(errors.Wrap(err, "wrapped")))
+	require.Equal(t, codes.Unknown, statusCode(errors.New("plain")))
+	require.Equal(t, codes.OK, statusCode(nil))
+}
+
+func TestKoanfSecretRetry(t *testing.T) {
+	type cfg struct {
+		Simple string `koanf:"simple" gcpsecret:"TEST_SECRET"`
+	}
+
+	client := newFakeSecretClient(map[string]string{"TEST_SECRET": "value"})
+	client.errs = []error{status.Error(codes.Unavailable, "unavailable"), status.Error(codes.ResourceExhausted, "quota")}
+
+	retry := Retry{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	p := newProvider(Config{Project: "test", Retry: retry}, &cfg{}, nil, client)
+
+	k := koanf.New(".")
+	require.NoError(t, k.Load(p, nil))
+	require.Equal(t, "value", k.String("simple"))
+	require.Equal(t, 3, client.calls)
+}
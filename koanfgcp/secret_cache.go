@@ -0,0 +1,172 @@
+package koanfgcp
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Cache stores fetched secrets on disk to speed up repeated runs, e.g. when
+// restarting a development server. Entries are encrypted with AES-256-GCM
+// using a key kept outside the cache directory, written with owner only
+// permissions, keyed by project, secret name and requested version and
+// expire after the TTL. The cache is meant for local and CI runs, not for
+// cloud runtimes.
+type Cache struct {
+	dir     string
+	keyPath string
+	ttl     time.Duration
+}
+
+type cacheEntry struct {
+	Expires time.Time `json:"expires"`
+	Version string    `json:"version"`
+	Data    []byte    `json:"data"`
+}
+
+// NewCache returns a cache storing secrets in dir for ttl, encrypted with the
+// key in keyPath. A new key is generated if keyPath does not exist. keyPath
+// must not be inside dir, as anyone able to read the entries could then read
+// the key too. dir is created, or restricted if it exists, with owner only
+// permissions.
+func NewCache(dir, keyPath string, ttl time.Duration) (*Cache, error) {
+	if ttl <= 0 {
+		return nil, errors.New("cache ttl must be positive")
+	}
+
+	if inDir(dir, keyPath) {
+		return nil, errors.New("cache key must not be stored inside the cache directory")
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, errors.Wrap(err, "could not create cache directory")
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not stat cache directory")
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		if err := os.Chmod(dir, 0o700); err != nil {
+			return nil, errors.Wrap(err, "could not restrict cache directory permissions")
+		}
+	}
+
+	if _, err := os.Stat(keyPath); os.IsNotExist(err) {
+		if err := GenerateLocalSecretsKey(keyPath); err != nil && !os.IsExist(errors.Cause(err)) {
+			return nil, err
+		}
+	}
+
+	return &Cache{dir: dir, keyPath: keyPath, ttl: ttl}, nil
+}
+
+// inDir reports whether path is dir or inside it.
+func inDir(dir, path string) bool {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(absDir, absPath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Invalidate removes all cached versions of the secret referenced by ref, in
+// any form accepted by the gcpsecret tag, e.g. DB_PASS, shared-infra/DB_PASS
+// or projects/p/locations/europe-west1/secrets/DB_PASS. project is used for
+// references without a project. The version and options of ref are ignored,
+// and regional secrets must be referenced with their location.
+func (c *Cache) Invalidate(project, ref string) error {
+	parsed, err := parseSecretRef(ref)
+	if err != nil {
+		return err
+	}
+	if parsed.regional && parsed.location == "" {
+		return errors.New("regional secret " + parsed.name + " must be referenced with its location")
+	}
+	return os.RemoveAll(filepath.Dir(c.path(project, parsed)))
+}
+
+// Clear removes all cached secrets. The cache key is kept.
+func (c *Cache) Clear() error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(c.dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// get returns the cached secret for ref in project, if present and not expired.
+func (c *Cache) get(project string, ref secretRef) (fetchedSecret, bool) {
+	path := c.path(project, ref)
+	sealed, err := os.ReadFile(path)
+	if err != nil {
+		return fetchedSecret{}, false
+	}
+
+	aead, err := localSecretsCipher(c.keyPath)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return fetchedSecret{}, false
+	}
+
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	b, err := aead.Open(nil, nonce, ciphertext, []byte(path))
+	if err != nil {
+		return fetchedSecret{}, false
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(b, &entry); err != nil || time.Now().After(entry.Expires) {
+		return fetchedSecret{}, false
+	}
+
+	return fetchedSecret{ref: ref, version: entry.Version, data: entry.Data}, true
+}
+
+// put stores secret, fetched for ref in project.
+func (c *Cache) put(project string, ref secretRef, secret fetchedSecret) error {
+	aead, err := localSecretsCipher(c.keyPath)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(cacheEntry{Expires: time.Now().Add(c.ttl), Version: secret.version, Data: secret.data})
+	if err != nil {
+		return err
+	}
+
+	path := c.path(project, ref)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return errors.Wrap(err, "could not generate nonce")
+	}
+	sealed := aead.Seal(nonce, nonce, b, []byte(path))
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, sealed, 0o600)
+}
+
+func (c *Cache) path(project string, ref secretRef) string {
+	if ref.project != "" {
+		project = ref.project
+	}
+	if ref.location != "" {
+		project = filepath.Join(project, "locations", ref.location)
+	}
+	return filepath.Join(c.dir, project, ref.name, ref.version)
+}
@@ -0,0 +1,171 @@
+package koanfgcp
+
+import (
+	"context"
+	"github.com/knadh/koanf/v2"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestKoanfSecretCache(t *testing.T) {
+	type cfg struct {
+		Simple string `koanf:"simple" gcpsecret:"TEST_SECRET"`
+		Pinned string `koanf:"pinned" gcpsecret:"TEST_SECRET@1"`
+	}
+
+	dir := t.TempDir()
+	keyPath := filepath.Join(t.TempDir(), "cache.key")
+	cache, err := NewCache(dir, keyPath, time.Hour)
+	require.NoError(t, err)
+
+	client := newFakeSecretClient(map[string]string{"TEST_SECRET": "v1"})
+	load := func() *koanf.Koanf {
+		k := koanf.New(".")
+		require.NoError(t, k.Load(newProvider(Config{Project: "test", Cache: cache}, &cfg{}, nil, client), nil))
+		return k
+	}
+
+	require.Equal(t, "v1", load().String("simple"))
+	require.Equal(t, 2, client.calls)
+
+	client.addVersion("TEST_SECRET", "v2")
+	require.Equal(t, "v1", load().String("simple"), "latest must be served from the cache")
+	require.Equal(t, 2, client.calls)
+
+	require.NoError(t, cache.Invalidate("test", "TEST_SECRET@1,optional"), "versions and options must be ignored")
+	k := load()
+	require.Equal(t, "v2", k.String("simple"))
+	require.Equal(t, "v1", k.String("pinned"))
+	require.Equal(t, 4, client.calls)
+
+	require.NoError(t, cache.Clear())
+	load()
+	require.Equal(t, 6, client.calls)
+}
+
+func TestCacheEntries(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(t.TempDir(), "cache.key")
+	cache, err := NewCache(dir, keyPath, time.Hour)
+	require.NoError(t, err)
+
+	ref := secretRef{name: "DB_PASS", version: "latest"}
+	require.NoError(t, cache.put("test", ref, fetchedSecret{ref: ref, version: "projects/test/secrets/DB_PASS/versions/3", data: []byte("plaintext-secret")}))
+
+	path := filepath.Join(dir, "test", "DB_PASS", "latest")
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NotContains(t, string(b), "plaintext-secret")
+
+	secret, ok := cache.get("test", ref)
+	require.True(t, ok)
+	require.Equal(t, "projects/test/secrets/DB_PASS/versions/3", secret.version)
+	require.Equal(t, []byte("plaintext-secret"), secret.data)
+
+	_, ok = cache.get("other", ref)
+	require.False(t, ok)
+
+	regional := secretRef{location: "europe-west1", name: "DB_PASS", version: "latest", regional: true}
+	_, ok = cache.get("test", regional)
+	require.False(t, ok, "regional and global secrets must not share entries")
+	require.NoError(t, cache.put("test", regional, fetchedSecret{ref: regional, data: []byte("eu")}))
+	require.FileExists(t, filepath.Join(dir, "test", "locations", "europe-west1", "DB_PASS", "latest"))
+	require.NoError(t, cache.Invalidate("test", "projects/test/locations/europe-west1/secrets/DB_PASS"))
+	_, ok = cache.get("test", regional)
+	require.False(t, ok)
+	require.Error(t, cache.Invalidate("test", "DB_PASS,regional"))
+	require.Error(t, cache.Invalidate("test", "DB_PASS,xml"))
+
+	require.NoError(t, cache.put("test", secretRef{project: "shared", name: "DB_PASS", version: "2"}, secret))
+	require.NoError(t, cache.Invalidate("other", "shared/DB_PASS"))
+	require.NoDirExists(t, filepath.Join(dir, "shared", "DB_PASS"))
+
+	expired, err := NewCache(dir, keyPath, time.Nanosecond)
+	require.NoError(t, err)
+	require.NoError(t, expired.put("test", ref, secret))
+	time.Sleep(time.Millisecond)
+	_, ok = expired.get("test", ref)
+	require.False(t, ok)
+
+	_, err = NewCache(dir, keyPath, 0)
+	require.Error(t, err)
+}
+
+func TestNewCacheKeyAndPermissions(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cache")
+	require.NoError(t, os.Mkdir(dir, 0o755))
+
+	_, err := NewCache(dir, filepath.Join(dir, "cache.key"), time.Hour)
+	require.Error(t, err, "the key must not be stored next to the entries")
+	_, err = NewCache(dir, dir, time.Hour)
+	require.Error(t, err)
+
+	keyPath := filepath.Join(t.TempDir(), "cache.key")
+	_, err = NewCache(dir, keyPath, time.Hour)
+	require.NoError(t, err)
+	require.FileExists(t, keyPath)
+
+	info, err := os.Stat(dir)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o700), info.Mode().Perm(), "an existing cache directory must be restricted")
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestKoanfSecretWatchSkipsCache(t *testing.T) {
+	type cfg struct {
+		Simple string `koanf:"simple" gcpsecret:"TEST_SECRET"`
+	}
+
+	cache, err := NewCache(t.TempDir(), filepath.Join(t.TempDir(), "cache.key"), time.Hour)
+	require.NoError(t, err)
+
+	client := newFakeSecretClient(map[string]string{"TEST_SECRET": "v1"})
+	p := newProvider(Config{Project: "test", Cache: cache, WatchInterval: 10 * time.Millisecond}, &cfg{}, nil, client)
+	k := koanf.New(".")
+	require.NoError(t, k.Load(p, nil))
+
+	events := make(chan []VersionChange, 1)
+	require.NoError(t, p.Watch(func(event interface{}, err error) {
+		require.NoError(t, err)
+		events <- event.([]VersionChange)
+	}))
+	defer p.Unwatch()
+
+	client.addVersion("TEST_SECRET", "v2")
+
+	select {
+	case changes := <-events:
+		require.Equal(t, "projects/test/secrets/TEST_SECRET/versions/2", changes[0].NewVersion)
+	case <-time.After(time.Second):
+		t.Fatal("no version change reported")
+	}
+
+	require.NoError(t, k.Load(p, nil))
+	require.Equal(t, "v2", k.String("simple"), "polls must refresh the cache")
+}
+
+func TestKoanfSecretCacheRegionalWithoutEndpoint(t *testing.T) {
+	cache, err := NewCache(t.TempDir(), filepath.Join(t.TempDir(), "cache.key"), time.Hour)
+	require.NoError(t, err)
+
+	ref := secretRef{location: "europe-west1", name: "DB_PASS", version: "latest", regional: true}
+	require.NoError(t, cache.put("test", ref, fetchedSecret{ref: ref, version: "projects/test/locations/europe-west1/secrets/DB_PASS/versions/1", data: []byte("eu")}))
+
+	p := newProvider(Config{Project: "test", Region: "europe-west1", Cache: cache}, nil, nil, newFakeSecretClient(nil))
+	p.newClient = func(region string) (secretClient, error) {
+		return nil, errors.New("regional endpoint " + region + " is unreachable")
+	}
+
+	values, err := p.Resolve(context.Background(), map[string]string{"db": "DB_PASS,regional"})
+	require.NoError(t, err, "cached regional secrets must not dial the regional endpoint")
+	require.Equal(t, "eu", values["db"])
+}
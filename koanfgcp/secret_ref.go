@@ -0,0 +1,166 @@
+package koanfgcp
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strings"
+)
+
+const latestVersion = "latest"
+
+const (
+	formatJSON   = "json"
+	formatYAML   = "yaml"
+	formatBinary = "binary"
+)
+
+const optionOptional = "optional"
+const optionRegional = "regional"
+
+// secretRef is a parsed gcpsecret tag value. The following forms are
+// accepted, where VERSION is a version number or alias and defaults to latest:
+//
+//	NAME[@VERSION]
+//	PROJECT/NAME[@VERSION]
+//	projects/PROJECT/secrets/NAME[@VERSION]
+//	projects/PROJECT/secrets/NAME/versions/VERSION
+//	projects/PROJECT/locations/REGION/secrets/NAME[@VERSION]
+//	projects/PROJECT/locations/REGION/secrets/NAME/versions/VERSION
+//
+// An empty project means the project of the provider Config. The reference
+// may be followed by comma separated options:
+//
+//	json, yaml  parse the payload and expand it into the field's subtree
+//	binary      keep the payload as raw bytes, implied for []byte fields
+//	optional    a secret that does not exist leaves the key unset
+//	regional    a regional secret in the region of the provider Config
+type secretRef struct {
+	project  string
+	location string
+	name     string
+	version  string
+	format   string
+	optional bool
+	regional bool
+}
+
+func parseSecretRef(tag string) (secretRef, error) {
+	ref, options := splitSecretTag(tag)
+
+	var format string
+	var optional, regional bool
+	for _, option := range options {
+		switch option {
+		case optionOptional:
+			optional = true
+		case optionRegional:
+			regional = true
+		case formatJSON, formatYAML, formatBinary:
+			if format != "" {
+				return secretRef{}, errors.New(fmt.Sprintf("secret reference '%s' has more than one format", tag))
+			}
+			format = option
+		default:
+			return secretRef{}, errors.New(fmt.Sprintf("secret reference '%s' has unknown option '%s'", tag, option))
+		}
+	}
+
+	r, err := parseSecretPath(ref)
+	if err != nil {
+		return secretRef{}, err
+	}
+	r.format = format
+	r.optional = optional
+	r.regional = regional || r.location != ""
+	return r, nil
+}
+
+// SecretName returns the name of the secret referenced by a gcpsecret tag
+// value, without project, location, version or options. Invalid references
+// are returned without their options.
+func SecretName(tag string) string {
+	ref, err := parseSecretRef(tag)
+	if err != nil {
+		name, _ := splitSecretTag(tag)
+		return name
+	}
+	return ref.name
+}
+
+// splitSecretTag splits a gcpsecret tag value into the secret reference and its options.
+func splitSecretTag(tag string) (string, []string) {
+	parts := strings.Split(tag, ",")
+	options := parts[1:]
+	for i := range options {
+		options[i] = strings.TrimSpace(options[i])
+	}
+	return parts[0], options
+}
+
+func parseSecretPath(ref string) (secretRef, error) {
+	path, version := ref, latestVersion
+	if i := strings.LastIndex(ref, "@"); i != -1 {
+		path, version = ref[:i], ref[i+1:]
+		if version == "" {
+			return secretRef{}, errors.New(fmt.Sprintf("secret reference '%s' has an empty version", ref))
+		}
+	}
+
+	var r secretRef
+	parts := strings.Split(path, "/")
+	switch {
+	case len(parts) == 1:
+		r = secretRef{name: parts[0], version: version}
+	case len(parts) == 2:
+		r = secretRef{project: parts[0], name: parts[1], version: version}
+	case len(parts) == 4 && parts[0] == "projects" && parts[2] == "secrets":
+		r = secretRef{project: parts[1], name: parts[3], version: version}
+	case len(parts) == 6 && parts[0] == "projects" && parts[2] == "secrets" && parts[4] == "versions" && version == latestVersion:
+		r = secretRef{project: parts[1], name: parts[3], version: parts[5]}
+	case len(parts) == 6 && parts[0] == "projects" && parts[2] == "locations" && parts[4] == "secrets":
+		r = secretRef{project: parts[1], location: parts[3], name: parts[5], version: version}
+	case len(parts) == 8 && parts[0] == "projects" && parts[2] == "locations" && parts[4] == "secrets" && parts[6] == "versions" && version == latestVersion:
+		r = secretRef{project: parts[1], location: parts[3], name: parts[5], version: parts[7]}
+	default:
+		return secretRef{}, errors.New(fmt.Sprintf("secret reference '%s' is not a valid secret name", ref))
+	}
+
+	if r.name == "" || r.version == "" || (len(parts) > 1 && r.project == "") || (len(parts) >= 6 && parts[2] == "locations" && r.location == "") {
+		return secretRef{}, errors.New(fmt.Sprintf("secret reference '%s' is not a valid secret name", ref))
+	}
+
+	return r, nil
+}
+
+// withVersions applies a version override from versions, keyed by secret name.
+func (r secretRef) withVersions(versions map[string]string) secretRef {
+	if version, ok := versions[r.name]; ok && version != "" {
+		r.version = version
+	}
+	return r
+}
+
+// withRegion makes a regional reference without a location of its own
+// refer to region.
+func (r secretRef) withRegion(region string) (secretRef, error) {
+	if !r.regional || r.location != "" {
+		return r, nil
+	}
+	if region == "" {
+		return secretRef{}, errors.New(fmt.Sprintf("secret %s is regional but no region is configured", r.name))
+	}
+	r.location = region
+	return r, nil
+}
+
+// versionName returns the secret version resource name, using project if the
+// reference does not name a project of its own.
+func (r secretRef) versionName(project string) string {
+	if r.project != "" {
+		project = r.project
+	}
+	if r.location != "" {
+		return fmt.Sprintf("projects/%s/locations/%s/secrets/%s/versions/%s", project, r.location, r.name, r.version)
+	}
+	return fmt.Sprintf("projects/%s/secrets/%s/versions/%s", project, r.name, r.version)
+}
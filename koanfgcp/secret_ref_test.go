@@ -0,0 +1,86 @@
+package koanfgcp
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_parseSecretRef(t *testing.T) {
+	tests := []struct {
+		name    string
+		ref     string
+		want    secretRef
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{"name only", "DB_PASS", secretRef{name: "DB_PASS", version: "latest"}, assert.NoError},
+		{"pinned version", "DB_PASS@7", secretRef{name: "DB_PASS", version: "7"}, assert.NoError},
+		{"version alias", "DB_PASS@stable", secretRef{name: "DB_PASS", version: "stable"}, assert.NoError},
+		{"short project form", "shared-infra/SENTRY_DSN", secretRef{project: "shared-infra", name: "SENTRY_DSN", version: "latest"}, assert.NoError},
+		{"short project form with version", "shared-infra/SENTRY_DSN@3", secretRef{project: "shared-infra", name: "SENTRY_DSN", version: "3"}, assert.NoError},
+		{"resource name", "projects/shared-infra/secrets/SENTRY_DSN", secretRef{project: "shared-infra", name: "SENTRY_DSN", version: "latest"}, assert.NoError},
+		{"resource name with version", "projects/shared-infra/secrets/SENTRY_DSN/versions/3", secretRef{project: "shared-infra", name: "SENTRY_DSN", version: "3"}, assert.NoError},
+		{"resource name with alias", "projects/shared-infra/secrets/SENTRY_DSN@stable", secretRef{project: "shared-infra", name: "SENTRY_DSN", version: "stable"}, assert.NoError},
+		{"json option", "DB_CREDS,json", secretRef{name: "DB_CREDS", version: "latest", format: "json"}, assert.NoError},
+		{"yaml option with version", "DB_CREDS@2, yaml", secretRef{name: "DB_CREDS", version: "2", format: "yaml"}, assert.NoError},
+		{"optional", "DB_PASS,optional", secretRef{name: "DB_PASS", version: "latest", optional: true}, assert.NoError},
+		{"optional json", "DB_CREDS@3,json,optional", secretRef{name: "DB_CREDS", version: "3", format: "json", optional: true}, assert.NoError},
+		{"regional option", "DB_PASS@3,regional", secretRef{name: "DB_PASS", version: "3", regional: true}, assert.NoError},
+		{"regional resource name", "projects/p/locations/europe-west1/secrets/DB_PASS", secretRef{project: "p", location: "europe-west1", name: "DB_PASS", version: "latest", regional: true}, assert.NoError},
+		{"regional resource name with version", "projects/p/locations/europe-west1/secrets/DB_PASS/versions/2", secretRef{project: "p", location: "europe-west1", name: "DB_PASS", version: "2", regional: true}, assert.NoError},
+		{"regional resource name without location", "projects/p/locations//secrets/DB_PASS", secretRef{}, assert.Error},
+		{"two formats", "DB_CREDS,json,yaml", secretRef{}, assert.Error},
+		{"unknown option", "DB_CREDS,xml", secretRef{}, assert.Error},
+		{"empty version", "DB_PASS@", secretRef{}, assert.Error},
+		{"empty name", "@7", secretRef{}, assert.Error},
+		{"empty project", "/DB_PASS", secretRef{}, assert.Error},
+		{"two versions", "projects/p/secrets/DB_PASS/versions/3@4", secretRef{}, assert.Error},
+		{"invalid resource name", "projects/p/keys/DB_PASS", secretRef{}, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSecretRef(tt.ref)
+			if !tt.wantErr(t, err, fmt.Sprintf("parseSecretRef(%v)", tt.ref)) {
+				return
+			}
+			assert.Equalf(t, tt.want, got, "parseSecretRef(%v)", tt.ref)
+		})
+	}
+}
+
+func Test_secretRef_withVersions(t *testing.T) {
+	ref := secretRef{name: "DB_PASS", version: "7"}
+	assert.Equal(t, ref, ref.withVersions(nil))
+	assert.Equal(t, ref, ref.withVersions(map[string]string{"OTHER": "3"}))
+	assert.Equal(t, secretRef{name: "DB_PASS", version: "6"}, ref.withVersions(map[string]string{"DB_PASS": "6"}))
+	assert.Equal(t, "projects/p/secrets/DB_PASS/versions/7", ref.versionName("p"))
+	assert.Equal(t, "projects/shared/secrets/DB_PASS/versions/7", secretRef{project: "shared", name: "DB_PASS", version: "7"}.versionName("p"))
+}
+
+func Test_secretRef_withRegion(t *testing.T) {
+	global := secretRef{name: "DB_PASS", version: "latest"}
+	got, err := global.withRegion("europe-west1")
+	assert.NoError(t, err)
+	assert.Equal(t, global, got)
+
+	regional := secretRef{name: "DB_PASS", version: "latest", regional: true}
+	got, err = regional.withRegion("europe-west1")
+	assert.NoError(t, err)
+	assert.Equal(t, "projects/p/locations/europe-west1/secrets/DB_PASS/versions/latest", got.versionName("p"))
+
+	located := secretRef{project: "shared", location: "us-east1", name: "DB_PASS", version: "latest", regional: true}
+	got, err = located.withRegion("europe-west1")
+	assert.NoError(t, err)
+	assert.Equal(t, "projects/shared/locations/us-east1/secrets/DB_PASS/versions/latest", got.versionName("p"))
+
+	_, err = regional.withRegion("")
+	assert.Error(t, err)
+}
+
+func TestSecretName(t *testing.T) {
+	assert.Equal(t, "DB_PASS", SecretName("DB_PASS"))
+	assert.Equal(t, "DB_CREDS", SecretName("DB_CREDS,json"))
+	assert.Equal(t, "SENTRY_DSN", SecretName("shared-infra/SENTRY_DSN@3,optional"))
+	assert.Equal(t, "DB_PASS", SecretName("projects/p/locations/europe-west1/secrets/DB_PASS/versions/2"))
+	assert.Equal(t, "DB_CREDS", SecretName("DB_CREDS,xml"))
+}
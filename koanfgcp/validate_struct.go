@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/pkg/errors"
 	"reflect"
+	"strconv"
 	"strings"
 )
 
@@ -35,6 +36,9 @@ func validateStruct(cfg interface{}) (reflect.Value, error) {
 		fieldType := field.Kind()
 		fieldName := field.Type().Name()
 		_ = fieldName
+		if err := validateSecretTag(rootValElem.Type().Field(i), field); err != nil {
+			return reflect.Value{}, err
+		}
 		if fieldType == reflect.Struct {
 			_, err := validateStruct(field.Addr().Interface())
 			if err != nil {
@@ -65,32 +69,129 @@ func validateField(field reflect.Value) error {
 	return nil
 }
 
+// validateSecretTag checks that struct typed fields with a gcpsecret tag
+// declare how the secret payload should be parsed.
+func validateSecretTag(structField reflect.StructField, field reflect.Value) error {
+	gcpTag := structField.Tag.Get(gcpSecretTag)
+	if gcpTag == "" || !isStruct(field) {
+		return nil
+	}
+
+	ref, err := parseSecretRef(gcpTag)
+	if err != nil {
+		return err
+	}
+	if ref.format == "" {
+		return errors.New(fmt.Sprintf("field %s is a struct - its gcpsecret tag must have the json or yaml option", structField.Name))
+	}
+	return nil
+}
+
+func isStruct(field reflect.Value) bool {
+	return field.Kind() == reflect.Struct || (field.Kind() == reflect.Ptr && field.Type().Elem().Kind() == reflect.Struct)
+}
+
+func isBytes(field reflect.Value) bool {
+	return field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Uint8
+}
+
+// hasSecretTags reports whether the struct, pointer, slice, array or map type
+// t contains a field with a gcpsecret tag, at any depth.
+func hasSecretTags(t reflect.Type, seen map[reflect.Type]bool) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		return hasSecretTags(t.Elem(), seen)
+	case reflect.Struct:
+		if seen[t] {
+			return false
+		}
+		seen[t] = true
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).Tag.Get(gcpSecretTag) != "" || hasSecretTags(t.Field(i).Type, seen) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// resolveSecretNames returns the koanf key to gcpsecret tag pairs of the
+// struct s. Nil pointers to structs declaring secrets are allocated unless
+// skipNil is set, and secrets inside slices and maps of structs get indexed
+// keys such as tenants.0.password or backends.eu.password.
 func resolveSecretNames(skipNil bool, prefix []string, s reflect.Value) map[string]string {
 	secrets := make(map[string]string)
+	resolveStructSecretNames(skipNil, prefix, s, map[reflect.Type]bool{s.Type(): true}, secrets)
+	return secrets
+}
+
+func resolveStructSecretNames(skipNil bool, prefix []string, s reflect.Value, path map[reflect.Type]bool, secrets map[string]string) {
 	for i := 0; i < s.NumField(); i++ {
 		f := s.Field(i)
-		fieldType := f.Kind()
 		koanftag := s.Type().Field(i).Tag.Get(koanfTag)
-		koanfTagWithPrefix := append(prefix, koanftag)
+		koanfTagWithPrefix := append(append([]string{}, prefix...), koanftag)
 
 		gcpTag := s.Type().Field(i).Tag.Get(gcpSecretTag)
-		if fieldType == reflect.Struct || (fieldType == reflect.Ptr && f.Elem().Kind() == reflect.Struct) {
-			if fieldType == reflect.Ptr {
-				f = f.Elem()
-			}
-			res := resolveSecretNames(skipNil, koanfTagWithPrefix, f)
-			for k, v := range res {
-				secrets[k] = v
-			}
+		if gcpTag != "" && isStruct(f) {
+			// Structured secrets fill the whole subtree of the field
+			secrets[strings.Join(koanfTagWithPrefix, ".")] = gcpTag
 			continue
 		}
-
 		if gcpTag == "" {
+			resolveValueSecretNames(skipNil, koanfTagWithPrefix, f, path, secrets)
 			continue
 		}
 
+		if ref, err := parseSecretRef(gcpTag); err == nil && ref.format == "" && isBytes(f) {
+			gcpTag += "," + formatBinary
+		}
+
 		koanfKey := strings.Join(koanfTagWithPrefix, ".")
 		secrets[koanfKey] = gcpTag
 	}
-	return secrets
+}
+
+// resolveValueSecretNames collects the secrets of untagged struct, struct
+// pointer, slice and map values.
+func resolveValueSecretNames(skipNil bool, prefix []string, v reflect.Value, path map[reflect.Type]bool, secrets map[string]string) {
+	switch v.Kind() {
+	case reflect.Struct:
+		resolveStructSecretNames(skipNil, prefix, v, path, secrets)
+	case reflect.Ptr:
+		elem := v.Type().Elem()
+		if elem.Kind() != reflect.Struct {
+			return
+		}
+		if v.IsNil() {
+			// A nil pointer often means a feature is not configured, so only
+			// types declaring secrets are allocated. Recursive types are not
+			// allocated, as that would never end
+			if skipNil || path[elem] || !hasSecretTags(elem, map[reflect.Type]bool{}) {
+				return
+			}
+			if v.CanSet() {
+				v.Set(reflect.New(elem))
+			} else {
+				v = reflect.New(elem)
+			}
+		}
+		path[elem] = true
+		resolveStructSecretNames(skipNil, prefix, v.Elem(), path, secrets)
+		delete(path, elem)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			resolveValueSecretNames(skipNil, append(append([]string{}, prefix...), strconv.Itoa(i)), v.Index(i), path, secrets)
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			// Map values are not addressable, so nil pointers in them are allocated on a copy
+			elem := reflect.New(v.Type().Elem()).Elem()
+			elem.Set(iter.Value())
+			resolveValueSecretNames(skipNil, append(append([]string{}, prefix...), iter.Key().String()), elem, path, secrets)
+		}
+	}
 }
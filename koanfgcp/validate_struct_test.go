@@ -6,6 +6,16 @@ import (
 	"testing"
 )
 
+type node struct {
+	Password string `koanf:"password" gcpsecret:"NODE_PASS"`
+	Next     *node  `koanf:"next"`
+}
+
+type cfgRecursive struct {
+	Password string `koanf:"password" gcpsecret:"NODE_PASS"`
+	Next     *node  `koanf:"next"`
+}
+
 func Test_validateAndResolve(t *testing.T) {
 	type cfg struct {
 		Simple string `koanf:"simple" gcpsecret:"gcp_simple"`
@@ -24,6 +34,50 @@ func Test_validateAndResolve(t *testing.T) {
 		Ignore string `koanf:"ignore"`
 	}
 
+	type credentials struct {
+		User     string `koanf:"user"`
+		Password string `koanf:"password"`
+	}
+
+	type cfgStructured struct {
+		Simple      string       `koanf:"outer" gcpsecret:"outer_gcp"`
+		Credentials credentials  `koanf:"credentials" gcpsecret:"DB_CREDS,json"`
+		Pointer     *credentials `koanf:"pointer" gcpsecret:"DB_CREDS_YAML,yaml"`
+	}
+
+	type cfgStructuredWithoutFormat struct {
+		Credentials credentials `koanf:"credentials" gcpsecret:"DB_CREDS"`
+	}
+
+	type cfgBinary struct {
+		Keystore []byte `koanf:"keystore" gcpsecret:"KEYSTORE"`
+		Pinned   []byte `koanf:"pinned" gcpsecret:"KEYSTORE@2"`
+		Explicit []byte `koanf:"explicit" gcpsecret:"KEYSTORE,binary"`
+	}
+
+	type tenant struct {
+		Name     string `koanf:"name"`
+		Password string `koanf:"password" gcpsecret:"TENANT_PASS"`
+	}
+
+	type cfgSlice struct {
+		Tenants  []tenant  `koanf:"tenants"`
+		Pointers []*tenant `koanf:"pointers"`
+	}
+
+	type feature struct {
+		URL string `koanf:"url"`
+	}
+
+	type cfgPlainPointer struct {
+		Feature *feature `koanf:"feature"`
+	}
+
+	type cfgMap struct {
+		Backends map[string]tenant  `koanf:"backends"`
+		Pointers map[string]*tenant `koanf:"pointers"`
+	}
+
 	type args struct {
 		cfg interface{}
 	}
@@ -36,7 +90,14 @@ func Test_validateAndResolve(t *testing.T) {
 		{"simple", args{cfg: &cfg{}}, map[string]string{"simple": "gcp_simple"}, assert.NoError},
 		{"simple with nested", args{cfg: &cfgNested{}}, map[string]string{"nested.simple": "gcp_simple", "outer": "outer_gcp"}, assert.NoError},
 		{"simple with nested with pointer", args{cfg: &cfgNestedWithPointer{Nested: &cfg{}}}, map[string]string{"nested.simple": "gcp_simple", "outer": "outer_gcp"}, assert.NoError},
-		{"simple with nested with pointer that is nil", args{cfg: &cfgNestedWithPointer{Nested: nil}}, map[string]string{"outer": "outer_gcp"}, assert.NoError},
+		{"simple with nested with pointer that is nil", args{cfg: &cfgNestedWithPointer{Nested: nil}}, map[string]string{"nested.simple": "gcp_simple", "outer": "outer_gcp"}, assert.NoError},
+		{"slice", args{cfg: &cfgSlice{Tenants: []tenant{{}, {}}, Pointers: []*tenant{nil}}}, map[string]string{"tenants.0.password": "TENANT_PASS", "tenants.1.password": "TENANT_PASS", "pointers.0.password": "TENANT_PASS"}, assert.NoError},
+		{"map", args{cfg: &cfgMap{Backends: map[string]tenant{"eu": {}, "us": {}}, Pointers: map[string]*tenant{"eu": nil}}}, map[string]string{"backends.eu.password": "TENANT_PASS", "backends.us.password": "TENANT_PASS", "pointers.eu.password": "TENANT_PASS"}, assert.NoError},
+		{"pointer without secrets that is nil", args{cfg: &cfgPlainPointer{}}, map[string]string{}, assert.NoError},
+		{"recursive pointer that is nil", args{cfg: &cfgRecursive{}}, map[string]string{"password": "NODE_PASS", "next.password": "NODE_PASS"}, assert.NoError},
+		{"structured", args{cfg: &cfgStructured{}}, map[string]string{"outer": "outer_gcp", "credentials": "DB_CREDS,json", "pointer": "DB_CREDS_YAML,yaml"}, assert.NoError},
+		{"binary", args{cfg: &cfgBinary{}}, map[string]string{"keystore": "KEYSTORE,binary", "pinned": "KEYSTORE@2,binary", "explicit": "KEYSTORE,binary"}, assert.NoError},
+		{"structured without format", args{cfg: &cfgStructuredWithoutFormat{}}, nil, assert.Error},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -48,3 +109,24 @@ func Test_validateAndResolve(t *testing.T) {
 		})
 	}
 }
+
+func Test_validateAndResolveAllocation(t *testing.T) {
+	type feature struct {
+		URL string `koanf:"url"`
+	}
+
+	type db struct {
+		Password string `koanf:"password" gcpsecret:"DB_PASS"`
+	}
+
+	type cfg struct {
+		Feature *feature `koanf:"feature"`
+		DB      *db      `koanf:"db"`
+	}
+
+	c := &cfg{}
+	_, err := validateAndResolve(c)
+	assert.NoError(t, err)
+	assert.Nil(t, c.Feature, "pointers without secrets must stay nil")
+	assert.NotNil(t, c.DB)
+}
@@ -0,0 +1,162 @@
+// Package koanfgcptest provides an in-process Secret Manager server for
+// testing code that resolves secrets with koanfgcp, without GCP credentials.
+//
+//	srv := koanfgcptest.NewServer(map[string]string{"my-project/DB_PASS": "hunter2"})
+//	defer srv.Close()
+//	client, err := srv.Client(ctx)
+//	...
+//	provider := koanfgcp.ProviderWithClient(koanfgcp.Config{Project: "my-project"}, nil, client)
+package koanfgcptest
+
+import (
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"context"
+	"fmt"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"hash/crc32"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const bufSize = 1024 * 1024
+
+// Server is a fake Secret Manager server that serves secret versions from
+// memory over an in-process gRPC connection. Only AccessSecretVersion is
+// implemented.
+type Server struct {
+	secretmanagerpb.UnimplementedSecretManagerServiceServer
+
+	mux      sync.Mutex
+	secrets  map[string][][]byte
+	errs     map[string]error
+	requests []string
+
+	listener *bufconn.Listener
+	server   *grpc.Server
+}
+
+// NewServer starts a server seeded with secrets, keyed by secret name in the
+// PROJECT/NAME or projects/PROJECT/secrets/NAME form. Each secret starts with
+// a single version, version 1.
+func NewServer(secrets map[string]string) *Server {
+	s := &Server{
+		secrets:  map[string][][]byte{},
+		errs:     map[string]error{},
+		listener: bufconn.Listen(bufSize),
+		server:   grpc.NewServer(),
+	}
+	for name, payload := range secrets {
+		s.AddVersion(name, payload)
+	}
+
+	secretmanagerpb.RegisterSecretManagerServiceServer(s.server, s)
+	go s.server.Serve(s.listener)
+	return s
+}
+
+// Client returns a Secret Manager client connected to the server. The client
+// is closed by the caller.
+func (s *Server) Client(ctx context.Context) (*secretmanager.Client, error) {
+	dialer := func(ctx context.Context, _ string) (net.Conn, error) {
+		return s.listener.DialContext(ctx)
+	}
+	return secretmanager.NewClient(ctx,
+		option.WithEndpoint("bufnet"),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithContextDialer(dialer)),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())))
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.server.Stop()
+}
+
+// AddVersion adds a new latest version with payload to the secret name,
+// creating the secret if it does not exist.
+func (s *Server) AddVersion(name, payload string) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	name = secretName(name)
+	s.secrets[name] = append(s.secrets[name], []byte(payload))
+}
+
+// SetError makes every request for a version of the secret name fail with
+// err, e.g. status.Error(codes.PermissionDenied, "denied"). A nil err makes
+// the secret available again.
+func (s *Server) SetError(name string, err error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	name = secretName(name)
+	if err == nil {
+		delete(s.errs, name)
+		return
+	}
+	s.errs[name] = err
+}
+
+// Requests returns the secret version names requested so far, in order.
+func (s *Server) Requests() []string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// AccessSecretVersion implements secretmanagerpb.SecretManagerServiceServer.
+func (s *Server) AccessSecretVersion(ctx context.Context, req *secretmanagerpb.AccessSecretVersionRequest) (*secretmanagerpb.AccessSecretVersionResponse, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.requests = append(s.requests, req.Name)
+
+	i := strings.LastIndex(req.Name, "/versions/")
+	if i == -1 {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("invalid secret version name %s", req.Name))
+	}
+	name, version := req.Name[:i], req.Name[i+len("/versions/"):]
+
+	if err, ok := s.errs[name]; ok {
+		return nil, err
+	}
+
+	versions, ok := s.secrets[name]
+	if !ok {
+		return nil, status.Error(codes.NotFound, fmt.Sprintf("Secret [%s] not found or has no versions.", name))
+	}
+
+	n := len(versions)
+	if version != "latest" {
+		var err error
+		n, err = strconv.Atoi(version)
+		if err != nil || n < 1 || n > len(versions) {
+			return nil, status.Error(codes.NotFound, fmt.Sprintf("Secret Version [%s] not found.", req.Name))
+		}
+	}
+
+	data := versions[n-1]
+	checksum := int64(crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)))
+	return &secretmanagerpb.AccessSecretVersionResponse{
+		Name: fmt.Sprintf("%s/versions/%d", name, n),
+		Payload: &secretmanagerpb.SecretPayload{
+			Data:       data,
+			DataCrc32C: &checksum,
+		},
+	}, nil
+}
+
+// secretName returns the resource name of a secret given as PROJECT/NAME or
+// projects/PROJECT/secrets/NAME.
+func secretName(name string) string {
+	if strings.HasPrefix(name, "projects/") {
+		return name
+	}
+	project, secret, _ := strings.Cut(name, "/")
+	return fmt.Sprintf("projects/%s/secrets/%s", project, secret)
+}
@@ -0,0 +1,60 @@
+package koanfgcptest
+
+import (
+	"context"
+	"errors"
+	"github.com/mscno/konfig/koanfgcp"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	srv := NewServer(map[string]string{
+		"playground-mscno/TEST_SECRET":             "secret",
+		"projects/shared-infra/secrets/SENTRY_DSN": "dsn",
+		"playground-mscno/DB_CREDS":                `{"user": "app", "password": "hunter2"}`,
+		"playground-mscno/DENIED":                  "denied",
+	})
+	defer srv.Close()
+	srv.AddVersion("playground-mscno/TEST_SECRET", "rotated")
+	srv.SetError("playground-mscno/DENIED", status.Error(codes.PermissionDenied, "denied"))
+
+	client, err := srv.Client(ctx)
+	require.NoError(t, err)
+	defer client.Close()
+
+	p := koanfgcp.ProviderWithClient(koanfgcp.Config{Project: "playground-mscno"}, nil, client)
+	values, err := p.Resolve(ctx, map[string]string{
+		"latest":  "TEST_SECRET",
+		"pinned":  "TEST_SECRET@1",
+		"shared":  "shared-infra/SENTRY_DSN",
+		"db":      "DB_CREDS,json",
+		"missing": "MISSING,optional",
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{
+		"latest": "rotated",
+		"pinned": "secret",
+		"shared": "dsn",
+		"db":     map[string]interface{}{"user": "app", "password": "hunter2"},
+	}, values)
+	require.Equal(t, "projects/playground-mscno/secrets/TEST_SECRET/versions/2", p.SecretVersions()["latest"])
+	require.Len(t, srv.Requests(), 5)
+
+	_, err = p.Resolve(ctx, map[string]string{"denied": "DENIED", "missing": "MISSING"})
+	var fetchErrs koanfgcp.SecretFetchErrors
+	require.True(t, errors.As(err, &fetchErrs))
+	got := map[string]codes.Code{}
+	for _, fetchErr := range fetchErrs {
+		got[fetchErr.Key] = fetchErr.Code
+	}
+	require.Equal(t, map[string]codes.Code{"denied": codes.PermissionDenied, "missing": codes.NotFound}, got)
+
+	srv.SetError("playground-mscno/DENIED", nil)
+	values, err = p.Resolve(ctx, map[string]string{"denied": "DENIED"})
+	require.NoError(t, err)
+	require.Equal(t, "denied", values["denied"])
+}
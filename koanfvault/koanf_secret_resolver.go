@@ -0,0 +1,331 @@
+package koanfvault
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/knadh/koanf/maps"
+	"github.com/panjf2000/ants/v2"
+	"github.com/pkg/errors"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+const vaultSecretTag = "vaultsecret"
+const koanfTag = "koanf"
+const defaultConcurrency = 50
+const defaultAppRoleMount = "approle"
+
+// Config holds the Vault Configuration.
+type Config struct {
+	// Address of the Vault server. Defaults to VAULT_ADDR.
+	Address string
+
+	// Namespace is sent as X-Vault-Namespace when set.
+	Namespace string
+
+	// Token used to authenticate. Defaults to VAULT_TOKEN unless AppRole
+	// credentials are set.
+	Token string
+
+	// RoleID and SecretID log in with the AppRole auth method, mounted at
+	// AppRoleMount which defaults to "approle".
+	RoleID       string
+	SecretID     string
+	AppRoleMount string
+
+	// KVVersion is the version of the KV secrets engine, 1 or 2.
+	// Defaults to 2.
+	KVVersion int
+
+	// The Vault Delim that might be used
+	Delim string
+
+	//Concurrency is the number of goroutines to use when fetching secrets
+	Concurrency int
+
+	SkipKeys []string
+
+	// HTTPClient is used for all requests to Vault. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// Vault implements a Vault KV provider.
+type Vault struct {
+	config Config
+	token  string
+	target interface{}
+	cb     func(s string) string
+	ctx    context.Context
+}
+
+// Provider returns a Vault KV provider. If AppRole credentials are
+// configured it logs in to obtain a token.
+func Provider(ctx context.Context, cfg Config, target interface{}, cb func(s string) string) (*Vault, error) {
+	// check inputs and set
+	if cfg.Address == "" {
+		cfg.Address = os.Getenv("VAULT_ADDR")
+	}
+	if cfg.Address == "" {
+		return nil, errors.New("no vault address provided")
+	}
+	cfg.Address = strings.TrimSuffix(cfg.Address, "/")
+
+	if cfg.AppRoleMount == "" {
+		cfg.AppRoleMount = defaultAppRoleMount
+	}
+
+	if cfg.KVVersion == 0 {
+		cfg.KVVersion = 2
+	}
+	if cfg.KVVersion != 1 && cfg.KVVersion != 2 {
+		return nil, errors.New(fmt.Sprintf("unsupported kv version %d", cfg.KVVersion))
+	}
+
+	if cfg.Delim == "" {
+		cfg.Delim = "."
+	}
+
+	if cfg.Concurrency == 0 {
+		cfg.Concurrency = defaultConcurrency
+	}
+
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+
+	v := &Vault{config: cfg, target: target, cb: cb, ctx: ctx}
+
+	switch {
+	case cfg.RoleID != "":
+		token, err := v.loginAppRole(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not log in to vault with approle")
+		}
+		v.token = token
+	case cfg.Token != "":
+		v.token = cfg.Token
+	default:
+		v.token = os.Getenv("VAULT_TOKEN")
+	}
+
+	if v.token == "" {
+		return nil, errors.New("no vault token or approle credentials provided")
+	}
+
+	return v, nil
+}
+
+// SecretNames returns the koanf key to secret reference pairs declared by
+// the vaultsecret tags of cfg, which must be a pointer to a struct. It lets
+// Konfig.InitializeConfig collect vaultsecret instead of gcpsecret tags.
+func (v *Vault) SecretNames(cfg interface{}) (map[string]string, error) {
+	return validateAndResolve(cfg)
+}
+
+// Resolve fetches the secrets referenced in secrets, a map of koanf key to
+// secret reference, and returns their values keyed by koanf key.
+func (v *Vault) Resolve(ctx context.Context, secrets map[string]string) (map[string]interface{}, error) {
+	return v.getSecrets(ctx, secrets)
+}
+
+// context returns the context passed to Provider, used by the koanf
+// Provider methods that do not take one.
+func (v *Vault) context() context.Context {
+	if v.ctx == nil {
+		return context.Background()
+	}
+	return v.ctx
+}
+
+// Read returns the secrets referenced by the vaultsecret tags of the target.
+func (v *Vault) Read() (map[string]interface{}, error) {
+
+	// check if secretId is provided
+	if v.target == nil {
+		return nil, errors.New("no secret id  provided")
+	}
+
+	secretsToFetch, err := validateAndResolve(v.target)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range v.config.SkipKeys {
+		delete(secretsToFetch, k)
+	}
+
+	res, err := v.getSecrets(v.context(), secretsToFetch)
+	if err != nil {
+		return nil, err
+	}
+
+	mp := make(map[string]interface{})
+	for key, value := range res {
+		if v.cb != nil {
+			key = v.cb(key)
+		}
+		mp[key] = value
+	}
+
+	return maps.Unflatten(mp, v.config.Delim), nil
+}
+
+// ReadBytes is not supported by the Vault provider.
+func (v *Vault) ReadBytes() ([]byte, error) {
+	return nil, errors.New("vault provider does not support this method")
+}
+
+// Watch is not supported by the Vault provider.
+func (v *Vault) Watch(cb func(event interface{}, err error)) error {
+	return errors.New("vault provider does not support this method")
+}
+
+// getSecrets reads every referenced path once and picks the referenced
+// fields from the secret data.
+func (v *Vault) getSecrets(ctx context.Context, secretsToFetch map[string]string) (map[string]interface{}, error) {
+	var errs []string
+	keysByPath := map[string][]string{}
+	refs := map[string]secretRef{}
+	for key, tag := range secretsToFetch {
+		ref, err := parseSecretRef(tag)
+		if err != nil {
+			errs = append(errs, tag+": "+err.Error())
+			continue
+		}
+		refs[key] = ref
+		keysByPath[ref.path] = append(keysByPath[ref.path], key)
+	}
+
+	if len(errs) != 0 {
+		return nil, errors.New("Error when fetching secrets: " + strings.Join(errs, ", "))
+	}
+
+	var wg sync.WaitGroup
+	var mux sync.Mutex
+	res := map[string]interface{}{}
+
+	p, _ := ants.NewPoolWithFunc(v.config.Concurrency, func(i interface{}) {
+		defer wg.Done()
+		path := i.(string)
+
+		data, err := v.readSecret(ctx, path)
+
+		mux.Lock()
+		defer mux.Unlock()
+		if err != nil {
+			errs = append(errs, path+": "+err.Error())
+			return
+		}
+
+		for _, key := range keysByPath[path] {
+			ref := refs[key]
+			if ref.field == "" {
+				res[key] = data
+				continue
+			}
+			value, ok := data[ref.field]
+			if !ok {
+				errs = append(errs, ref.String()+": field not found")
+				continue
+			}
+			res[key] = value
+		}
+	})
+
+	for path := range keysByPath {
+		wg.Add(1)
+		_ = p.Invoke(path)
+	}
+
+	defer p.Release()
+
+	wg.Wait()
+
+	if len(errs) != 0 {
+		return nil, errors.New("Error when fetching secrets: " + strings.Join(errs, ", "))
+	}
+
+	return res, nil
+}
+
+// readSecret returns the data of the KV secret at path.
+func (v *Vault) readSecret(ctx context.Context, path string) (map[string]interface{}, error) {
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := v.do(ctx, http.MethodGet, path, nil, &secret); err != nil {
+		return nil, err
+	}
+
+	if v.config.KVVersion == 1 {
+		return secret.Data, nil
+	}
+
+	data, ok := secret.Data["data"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("response is not a kv v2 secret - check that the path contains the data segment")
+	}
+	return data, nil
+}
+
+func (v *Vault) loginAppRole(ctx context.Context) (string, error) {
+	body := map[string]string{"role_id": v.config.RoleID, "secret_id": v.config.SecretID}
+
+	var login struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := v.do(ctx, http.MethodPost, "auth/"+v.config.AppRoleMount+"/login", body, &login); err != nil {
+		return "", err
+	}
+
+	if login.Auth.ClientToken == "" {
+		return "", errors.New("login response has no client token")
+	}
+	return login.Auth.ClientToken, nil
+}
+
+// do sends a request to the Vault HTTP API and decodes the response into out.
+func (v *Vault) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return err
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, v.config.Address+"/v1/"+strings.TrimPrefix(path, "/"), &body)
+	if err != nil {
+		return err
+	}
+	if v.token != "" {
+		req.Header.Set("X-Vault-Token", v.token)
+	}
+	if v.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.config.Namespace)
+	}
+
+	resp, err := v.config.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&vaultErr)
+		if len(vaultErr.Errors) == 0 {
+			return errors.New(fmt.Sprintf("vault responded with status %d", resp.StatusCode))
+		}
+		return errors.New(fmt.Sprintf("vault responded with status %d: %s", resp.StatusCode, strings.Join(vaultErr.Errors, ", ")))
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
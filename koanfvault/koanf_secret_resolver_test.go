@@ -0,0 +1,160 @@
+package koanfvault
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/knadh/koanf/v2"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testToken = "s.test"
+
+type vaultServer struct {
+	*httptest.Server
+	reads int32
+}
+
+// newVaultServer starts a stand-in for the Vault API serving secrets keyed
+// by API path. Paths containing a data segment are served as KV v2 secrets.
+func newVaultServer(t *testing.T, secrets map[string]map[string]interface{}) *vaultServer {
+	srv := &vaultServer{}
+	srv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/v1/")
+
+		if r.Method == http.MethodPost && path == "auth/approle/login" {
+			var login map[string]string
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&login))
+			if login["role_id"] != "role" || login["secret_id"] != "secret" {
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(map[string][]string{"errors": {"invalid role or secret ID"}})
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]string{"client_token": testToken}})
+			return
+		}
+
+		if r.Header.Get("X-Vault-Token") != testToken {
+			w.WriteHeader(http.StatusForbidden)
+			_ = json.NewEncoder(w).Encode(map[string][]string{"errors": {"permission denied"}})
+			return
+		}
+
+		data, ok := secrets[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(map[string][]string{"errors": {}})
+			return
+		}
+		atomic.AddInt32(&srv.reads, 1)
+
+		if strings.Contains(path, "/data/") {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"data": data, "metadata": map[string]interface{}{"version": 1}}})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestKoanfSecret(t *testing.T) {
+	type database struct {
+		User     string `koanf:"user"`
+		Password string `koanf:"password"`
+	}
+
+	type cfg struct {
+		Password string   `koanf:"password" vaultsecret:"kv/data/app#password"`
+		User     string   `koanf:"user" vaultsecret:"kv/data/app#user"`
+		Database database `koanf:"database" vaultsecret:"kv/data/db"`
+		Ignore   string   `koanf:"ignore"`
+	}
+
+	srv := newVaultServer(t, map[string]map[string]interface{}{
+		"kv/data/app": {"user": "app", "password": "secret"},
+		"kv/data/db":  {"user": "db", "password": "db-secret"},
+	})
+
+	ctx := context.Background()
+	k := koanf.New(".")
+	p, err := Provider(ctx, Config{Address: srv.URL, Token: testToken}, &cfg{}, nil)
+	require.NoError(t, err)
+	require.NoError(t, k.Load(p, nil))
+
+	var out cfg
+	require.NoError(t, k.Unmarshal("", &out))
+	require.Equal(t, cfg{Password: "secret", User: "app", Database: database{User: "db", Password: "db-secret"}}, out)
+	require.Equal(t, int32(2), atomic.LoadInt32(&srv.reads))
+}
+
+func TestKoanfSecretKVv1AndAppRole(t *testing.T) {
+	type cfg struct {
+		Password string `koanf:"password" vaultsecret:"secret/app#password"`
+	}
+
+	srv := newVaultServer(t, map[string]map[string]interface{}{
+		"secret/app": {"password": "secret"},
+	})
+
+	ctx := context.Background()
+	k := koanf.New(".")
+	p, err := Provider(ctx, Config{Address: srv.URL, RoleID: "role", SecretID: "secret", KVVersion: 1}, &cfg{}, nil)
+	require.NoError(t, err)
+	require.NoError(t, k.Load(p, nil))
+	require.Equal(t, "secret", k.String("password"))
+
+	_, err = Provider(ctx, Config{Address: srv.URL, RoleID: "role", SecretID: "wrong"}, &cfg{}, nil)
+	require.ErrorContains(t, err, "invalid role or secret ID")
+}
+
+func TestKoanfSecretErrors(t *testing.T) {
+	type cfg struct {
+		Missing      string `koanf:"missing" vaultsecret:"kv/data/missing#password"`
+		MissingField string `koanf:"missing_field" vaultsecret:"kv/data/app#missing"`
+	}
+
+	srv := newVaultServer(t, map[string]map[string]interface{}{
+		"kv/data/app": {"password": "secret"},
+	})
+
+	p, err := Provider(context.Background(), Config{Address: srv.URL, Token: testToken}, &cfg{}, nil)
+	require.NoError(t, err)
+	_, err = p.Read()
+	require.ErrorContains(t, err, "kv/data/missing: vault responded with status 404")
+	require.ErrorContains(t, err, "kv/data/app#missing: field not found")
+
+	p, err = Provider(context.Background(), Config{Address: srv.URL, Token: "wrong"}, &cfg{}, nil)
+	require.NoError(t, err)
+	_, err = p.Read()
+	require.ErrorContains(t, err, "permission denied")
+}
+
+func TestKoanfSecretProviderContext(t *testing.T) {
+	type cfg struct {
+		Password string `koanf:"password" vaultsecret:"kv/data/app#password"`
+	}
+
+	// The server never answers, so requests only end with the Provider ctx
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(done) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	p, err := Provider(ctx, Config{Address: srv.URL, Token: testToken}, &cfg{}, nil)
+	require.NoError(t, err)
+
+	start := time.Now()
+	_, err = p.Read()
+	require.Error(t, err)
+	require.Less(t, time.Since(start), 5*time.Second)
+}
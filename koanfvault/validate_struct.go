@@ -0,0 +1,110 @@
+package koanfvault
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"reflect"
+	"strings"
+)
+
+// secretRef is a parsed vaultsecret tag value of the form PATH[#FIELD].
+// PATH is the API path of the secret, e.g. kv/data/app for KV v2. Without a
+// field the whole secret data is used as the value of a struct field.
+type secretRef struct {
+	path  string
+	field string
+}
+
+func parseSecretRef(tag string) (secretRef, error) {
+	path, field := tag, ""
+	if i := strings.LastIndex(tag, "#"); i != -1 {
+		path, field = tag[:i], tag[i+1:]
+		if field == "" {
+			return secretRef{}, errors.New(fmt.Sprintf("secret reference '%s' has an empty field", tag))
+		}
+	}
+
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return secretRef{}, errors.New(fmt.Sprintf("secret reference '%s' has an empty path", tag))
+	}
+
+	return secretRef{path: path, field: field}, nil
+}
+
+func (r secretRef) String() string {
+	if r.field == "" {
+		return r.path
+	}
+	return r.path + "#" + r.field
+}
+
+func validateAndResolve(cfg interface{}) (map[string]string, error) {
+	s, err := validateStruct(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return resolveSecretNames([]string{}, s), nil
+}
+
+func validateStruct(cfg interface{}) (reflect.Value, error) {
+	rootType := reflect.TypeOf(cfg)
+	rootKind := rootType.Kind()
+
+	if rootKind != reflect.Ptr {
+		return reflect.Value{}, errors.New("cfg argument must be a pointer to a struct, got:" + rootType.Kind().String())
+	}
+
+	rootValElem := reflect.ValueOf(cfg).Elem()
+	rootValElemKind := rootValElem.Kind()
+	if rootValElemKind != reflect.Struct {
+		return reflect.Value{}, errors.New("cfg argument must be a pointer to a struct, got: " + rootValElemKind.String())
+	}
+
+	for i := 0; i < rootValElem.NumField(); i++ {
+		field := rootValElem.Field(i)
+		if field.Kind() == reflect.Struct {
+			_, err := validateStruct(field.Addr().Interface())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			continue
+		}
+		if !field.IsValid() || !field.CanSet() {
+			return reflect.Value{}, errors.New(fmt.Sprintf("field %s is not valid - check if field is value and that it is exported from struct", field.Type().Name()))
+		}
+	}
+
+	return rootValElem, nil
+}
+
+func resolveSecretNames(prefix []string, s reflect.Value) map[string]string {
+	secrets := make(map[string]string)
+	for i := 0; i < s.NumField(); i++ {
+		f := s.Field(i)
+		fieldType := f.Kind()
+		koanftag := s.Type().Field(i).Tag.Get(koanfTag)
+		koanfTagWithPrefix := append(prefix, koanftag)
+
+		vaultTag := s.Type().Field(i).Tag.Get(vaultSecretTag)
+		if vaultTag == "" && (fieldType == reflect.Struct || (fieldType == reflect.Ptr && f.Elem().Kind() == reflect.Struct)) {
+			if fieldType == reflect.Ptr {
+				f = f.Elem()
+			}
+			res := resolveSecretNames(koanfTagWithPrefix, f)
+			for k, v := range res {
+				secrets[k] = v
+			}
+			continue
+		}
+
+		if vaultTag == "" {
+			continue
+		}
+
+		koanfKey := strings.Join(koanfTagWithPrefix, ".")
+		secrets[koanfKey] = vaultTag
+	}
+	return secrets
+}
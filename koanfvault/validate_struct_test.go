@@ -0,0 +1,63 @@
+package koanfvault
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func Test_validateAndResolve(t *testing.T) {
+	type cfg struct {
+		Simple string `koanf:"simple" vaultsecret:"kv/data/app#password"`
+		Ignore string `koanf:"ignore"`
+	}
+
+	type cfgNested struct {
+		Simple string `koanf:"outer" vaultsecret:"kv/data/app#outer"`
+		Nested *cfg   `koanf:"nested"`
+		Whole  cfg    `koanf:"whole" vaultsecret:"kv/data/whole"`
+	}
+
+	tests := []struct {
+		name    string
+		cfg     interface{}
+		want    map[string]string
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{"simple", &cfg{}, map[string]string{"simple": "kv/data/app#password"}, assert.NoError},
+		{"nested", &cfgNested{Nested: &cfg{}}, map[string]string{"outer": "kv/data/app#outer", "nested.simple": "kv/data/app#password", "whole": "kv/data/whole"}, assert.NoError},
+		{"nested with pointer that is nil", &cfgNested{}, map[string]string{"outer": "kv/data/app#outer", "whole": "kv/data/whole"}, assert.NoError},
+		{"not a pointer", cfg{}, nil, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := validateAndResolve(tt.cfg)
+			if !tt.wantErr(t, err, fmt.Sprintf("validateAndResolve(%v)", tt.cfg)) {
+				return
+			}
+			assert.Equalf(t, tt.want, got, "validateAndResolve(%v)", tt.cfg)
+		})
+	}
+}
+
+func Test_parseSecretRef(t *testing.T) {
+	tests := []struct {
+		ref     string
+		want    secretRef
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{"kv/data/app#password", secretRef{path: "kv/data/app", field: "password"}, assert.NoError},
+		{"/kv/data/app/", secretRef{path: "kv/data/app"}, assert.NoError},
+		{"kv/data/app#", secretRef{}, assert.Error},
+		{"#password", secretRef{}, assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			got, err := parseSecretRef(tt.ref)
+			if !tt.wantErr(t, err, fmt.Sprintf("parseSecretRef(%v)", tt.ref)) {
+				return
+			}
+			assert.Equalf(t, tt.want, got, "parseSecretRef(%v)", tt.ref)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package konfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// Secret is a string that keeps its value out of logs. Its String,
+// GoString, MarshalJSON and LogValue methods all return a placeholder, use
+// Value to get the secret itself.
+type Secret string
+
+// Value returns the secret value.
+func (s Secret) Value() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return redacted
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(redacted)
+}
+
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(redacted)
+}
+
+// IsSecret reports whether key, or a parent of key, was loaded from a
+// secret provider.
+func (k *Konfig) IsSecret(key string) bool {
+	for secret := range k.secretKeys {
+		if key == secret || strings.HasPrefix(key, secret+k.Delim()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redacted returns all keys and values like All, with the values of keys
+// loaded from a secret provider replaced by a placeholder.
+func (k *Konfig) Redacted() map[string]interface{} {
+	all := k.All()
+	for key, value := range all {
+		if k.IsSecret(key) {
+			all[key] = redacted
+			continue
+		}
+
+		// Slices are not flattened, so secrets such as tenants.0.password
+		// are masked inside the slice value of tenants
+		for secret := range k.secretKeys {
+			if rest := strings.TrimPrefix(secret, key+k.Delim()); rest != secret {
+				redactPath(value, strings.Split(rest, k.Delim()))
+			}
+		}
+	}
+	return all
+}
+
+func redactPath(node interface{}, path []string) {
+	parent, ok := lookupPath(node, path[:len(path)-1])
+	if !ok {
+		return
+	}
+	if m, ok := parent.(map[string]interface{}); ok {
+		if _, ok := m[path[len(path)-1]]; ok {
+			m[path[len(path)-1]] = redacted
+		}
+	}
+}
+
+// Print writes all keys and values to w like koanf's Print, with the values
+// of keys loaded from a secret provider replaced by a placeholder.
+func (k *Konfig) Print(w io.Writer) {
+	all := k.Redacted()
+	for _, key := range k.Keys() {
+		fmt.Fprintf(w, "%s -> %v\n", key, all[key])
+	}
+}
+
+func (k *Konfig) recordSecretKeys(values map[string]interface{}) {
+	if k.secretKeys == nil {
+		k.secretKeys = make(map[string]struct{}, len(values))
+	}
+	for key := range values {
+		k.secretKeys[key] = struct{}{}
+	}
+}
@@ -0,0 +1,83 @@
+package konfig
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"log/slog"
+	"testing"
+)
+
+func TestSecret(t *testing.T) {
+	type Config struct {
+		User     string `json:"user"`
+		Password Secret `json:"password"`
+	}
+
+	cfg := Config{User: "app", Password: "hunter2"}
+
+	assert.Equal(t, "hunter2", cfg.Password.Value())
+	for _, format := range []string{"%v", "%+v", "%#v", "%s", "%q"} {
+		assert.NotContains(t, fmt.Sprintf(format, cfg), "hunter2", format)
+	}
+	assert.Equal(t, "{app [REDACTED]}", fmt.Sprintf("%v", cfg))
+
+	b, err := json.Marshal(cfg)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"user": "app", "password": "[REDACTED]"}`, string(b))
+
+	var buf bytes.Buffer
+	slog.New(slog.NewTextHandler(&buf, nil)).Info("config", "password", cfg.Password)
+	assert.Contains(t, buf.String(), "password=[REDACTED]")
+	assert.NotContains(t, buf.String(), "hunter2")
+}
+
+func TestRedacted(t *testing.T) {
+	ctx := context.Background()
+	k := NewKonfig(
+		testProjectSet,
+		"us-central1",
+		WithDefaults(Defaults{"host": "localhost"}),
+		WithSecretResolver(fakeSecretResolver{"DB_PASS": "hunter2"}))
+
+	type Config struct {
+		Host     string `koanf:"host"`
+		Password Secret `koanf:"password" gcpsecret:"DB_PASS"`
+	}
+
+	cfg := &Config{}
+	err := k.InitializeConfig(ctx, cfg)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, "hunter2", cfg.Password.Value())
+	assert.True(t, k.IsSecret("password"))
+	assert.False(t, k.IsSecret("host"))
+
+	redacted := k.Redacted()
+	assert.Equal(t, "[REDACTED]", redacted["password"])
+	assert.Equal(t, "localhost", redacted["host"])
+	assert.Equal(t, "hunter2", k.String("password"), "Redacted must not modify the config")
+
+	var buf bytes.Buffer
+	k.Print(&buf)
+	assert.Contains(t, buf.String(), "password -> [REDACTED]\n")
+	assert.Contains(t, buf.String(), "host -> localhost\n")
+	assert.NotContains(t, buf.String(), "hunter2")
+}
+
+func TestRedactedSubtree(t *testing.T) {
+	k := NewKonfig(testProjectSet, "us-central1")
+	k.Set("database.user", "app")
+	k.Set("database.password", "hunter2")
+	k.Set("database_host", "localhost")
+	k.recordSecretKeys(map[string]interface{}{"database": nil})
+
+	redacted := k.Redacted()
+	assert.Equal(t, "[REDACTED]", redacted["database.user"])
+	assert.Equal(t, "[REDACTED]", redacted["database.password"])
+	assert.Equal(t, "localhost", redacted["database_host"])
+}
@@ -0,0 +1,225 @@
+package konfig
+
+import (
+	"context"
+	"github.com/knadh/koanf/v2"
+	"github.com/mscno/konfig/koanfgcp"
+	"github.com/pkg/errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecretVersioner is implemented by secret resolvers that report the
+// versions of the secrets they resolved last, keyed by koanf key, such as
+// koanfgcp.SMConfig.
+type SecretVersioner interface {
+	SecretVersions() map[string]string
+}
+
+// SecretChange describes a secret that changed when secrets were refreshed.
+// It never carries secret values. Key is the koanf key of the secret, or of
+// the value containing it for secrets in placeholders. Secret is the secret
+// name, e.g. DB_CREDS for gcpsecret:"shared-infra/DB_CREDS@3,json", or the
+// reference without tag options for resolvers implementing SecretNamer. The
+// versions are empty if the resolver does not implement SecretVersioner.
+type SecretChange struct {
+	Key        string
+	Secret     string
+	OldVersion string
+	NewVersion string
+}
+
+// secretState keeps what is needed to refresh the secrets loaded by
+// InitializeConfig and notify subscribers of changes.
+type secretState struct {
+	mux          sync.Mutex
+	resolver     SecretResolver
+	secrets      map[string]string
+	placeholders secretPlaceholders
+	values       map[string]interface{}
+	versions     map[string]string
+	current      *koanf.Koanf
+	subscribers  []func(change SecretChange)
+}
+
+// remember keeps the secrets resolved by InitializeConfig, keyed by config
+// key or, for secrets in placeholders, by placeholder resolver key.
+func (s *secretState) remember(resolver SecretResolver, secrets map[string]string, placeholders secretPlaceholders, values map[string]interface{}) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	s.resolver = resolver
+	s.secrets = secrets
+	s.placeholders = placeholders
+	s.values = values
+	s.versions = secretVersions(resolver)
+	s.current = nil
+}
+
+func secretVersions(resolver SecretResolver) map[string]string {
+	if versioner, ok := resolver.(SecretVersioner); ok {
+		return versioner.SecretVersions()
+	}
+	return nil
+}
+
+// secretName returns the name reported in a SecretChange for the secret
+// reference ref.
+func secretName(resolver SecretResolver, ref string) string {
+	if _, ok := resolver.(SecretNamer); ok {
+		name, _, _ := strings.Cut(ref, ",")
+		return name
+	}
+	return koanfgcp.SecretName(ref)
+}
+
+// OnSecretChange registers fn to be called for every secret that changed
+// when secrets are refreshed by RefreshSecrets or WatchSecrets.
+func (k *Konfig) OnSecretChange(fn func(change SecretChange)) {
+	k.secretState.mux.Lock()
+	defer k.secretState.mux.Unlock()
+	k.secretState.subscribers = append(k.secretState.subscribers, fn)
+}
+
+// Current returns the config with the secrets refreshed by RefreshSecrets
+// or WatchSecrets, or the embedded koanf.Koanf if no secret changed. Refreshes
+// never modify a returned koanf.Koanf, so it is safe to read concurrently.
+func (k *Konfig) Current() *koanf.Koanf {
+	k.secretState.mux.Lock()
+	defer k.secretState.mux.Unlock()
+	if k.secretState.current != nil {
+		return k.secretState.current
+	}
+	return k.Koanf
+}
+
+// RefreshSecrets resolves the secrets loaded by InitializeConfig again and
+// notifies the OnSecretChange subscribers, in key order, of the ones that
+// changed. Secrets changed when their version differs or, for resolvers that
+// do not report versions, when their value differs. Changed secrets are
+// loaded into a copy of the config, returned by Current, so the embedded
+// koanf.Koanf and the config struct passed to InitializeConfig keep their
+// values and can be read while secrets are refreshed.
+func (k *Konfig) RefreshSecrets(ctx context.Context) error {
+	s := &k.secretState
+	s.mux.Lock()
+	resolver, secrets, placeholders := s.resolver, s.secrets, s.placeholders
+	s.mux.Unlock()
+
+	if resolver == nil {
+		return nil
+	}
+
+	values, err := resolver.Resolve(ctx, secrets)
+	if err != nil {
+		return errors.Wrap(placeholders.renameErrors(err), "could not resolve secrets")
+	}
+
+	changes, subscribers, err := k.applyRefresh(resolver, secrets, placeholders, values, secretVersions(resolver))
+	if err != nil {
+		return err
+	}
+
+	for _, change := range changes {
+		for _, fn := range subscribers {
+			fn(change)
+		}
+	}
+	return nil
+}
+
+// applyRefresh records the refreshed values and versions, loads the changed
+// values into a new current config and returns the changes, sorted by key,
+// with the subscribers to notify. A changed secret in a placeholder is
+// reported for every config key containing the placeholder.
+func (k *Konfig) applyRefresh(resolver SecretResolver, secrets map[string]string, placeholders secretPlaceholders, values map[string]interface{}, versions map[string]string) ([]SecretChange, []func(change SecretChange), error) {
+	s := &k.secretState
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	var changes []SecretChange
+	changed := map[string]interface{}{}
+	var interpolate []string
+	for key, value := range values {
+		oldVersion, newVersion := s.versions[key], versions[key]
+		if oldVersion == newVersion && reflect.DeepEqual(s.values[key], value) {
+			continue
+		}
+		change := SecretChange{Key: key, Secret: secretName(resolver, secrets[key]), OldVersion: oldVersion, NewVersion: newVersion}
+		if _, ok := placeholders.secrets[key]; !ok {
+			changed[key] = value
+			changes = append(changes, change)
+			continue
+		}
+		for _, configKey := range placeholders.configKeys(key) {
+			change.Key = configKey
+			changes = append(changes, change)
+			interpolate = append(interpolate, configKey)
+		}
+	}
+	if len(changes) == 0 {
+		s.values = values
+		s.versions = versions
+		return nil, nil, nil
+	}
+
+	interpolated := map[string]interface{}{}
+	if len(interpolate) != 0 {
+		var err error
+		interpolated, err = placeholders.interpolate(values, interpolate)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// The config being read is never modified, changes go into a copy
+	current := k.Koanf
+	if s.current != nil {
+		current = s.current
+	}
+	current = current.Copy()
+	for key, value := range interpolated {
+		if err := current.Set(key, value); err != nil {
+			return nil, nil, errors.Wrap(err, "could not set interpolated value")
+		}
+	}
+	if err := loadSecretValues(current, changed); err != nil {
+		return nil, nil, errors.Wrap(err, "could not load secrets")
+	}
+
+	s.current = current
+	s.values = values
+	s.versions = versions
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].Key != changes[j].Key {
+			return changes[i].Key < changes[j].Key
+		}
+		return changes[i].Secret < changes[j].Secret
+	})
+	return changes, append([]func(change SecretChange){}, s.subscribers...), nil
+}
+
+// WatchSecrets calls RefreshSecrets every interval until ctx is done.
+// Errors are passed to onError, which may be nil. Refreshed secrets are read
+// with Current, see RefreshSecrets.
+func (k *Konfig) WatchSecrets(ctx context.Context, interval time.Duration, onError func(err error)) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if err := k.RefreshSecrets(ctx); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,215 @@
+package konfig
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeVersionedSecretResolver struct {
+	mux      sync.Mutex
+	secrets  map[string]string
+	versions map[string]string
+	last     map[string]string
+}
+
+func (f *fakeVersionedSecretResolver) set(name, value, version string) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.secrets[name] = value
+	f.versions[name] = version
+}
+
+func (f *fakeVersionedSecretResolver) Resolve(ctx context.Context, secrets map[string]string) (map[string]interface{}, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	res := map[string]interface{}{}
+	f.last = map[string]string{}
+	for key, name := range secrets {
+		res[key] = f.secrets[name]
+		f.last[key] = f.versions[name]
+	}
+	return res, nil
+}
+
+func (f *fakeVersionedSecretResolver) SecretVersions() map[string]string {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	return f.last
+}
+
+// unversionedSecretResolver hides SecretVersions of the wrapped resolver.
+type unversionedSecretResolver struct {
+	resolver *fakeVersionedSecretResolver
+}
+
+func (u unversionedSecretResolver) Resolve(ctx context.Context, secrets map[string]string) (map[string]interface{}, error) {
+	return u.resolver.Resolve(ctx, secrets)
+}
+
+func TestOnSecretChange(t *testing.T) {
+	ctx := context.Background()
+	resolver := &fakeVersionedSecretResolver{
+		secrets:  map[string]string{"DB_PASS": "v1", "API_KEY": "key"},
+		versions: map[string]string{"DB_PASS": "1", "API_KEY": "1"},
+	}
+	k := NewKonfig(testProjectSet, "us-central1", WithSecretResolver(resolver))
+
+	type Config struct {
+		Password string `koanf:"password" gcpsecret:"DB_PASS"`
+		APIKey   string `koanf:"api_key" gcpsecret:"API_KEY"`
+	}
+
+	cfg := &Config{}
+	err := k.InitializeConfig(ctx, cfg)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var changes []SecretChange
+	k.OnSecretChange(func(change SecretChange) {
+		changes = append(changes, change)
+	})
+
+	assert.NoError(t, k.RefreshSecrets(ctx))
+	assert.Empty(t, changes)
+
+	resolver.set("DB_PASS", "v2", "2")
+	assert.NoError(t, k.RefreshSecrets(ctx))
+	assert.Equal(t, []SecretChange{{Key: "password", Secret: "DB_PASS", OldVersion: "1", NewVersion: "2"}}, changes)
+	assert.Equal(t, "v2", k.Current().String("password"))
+	assert.Equal(t, "key", k.Current().String("api_key"))
+	assert.Equal(t, "v1", k.String("password"), "refreshes must not modify the config being read")
+
+	assert.NoError(t, k.RefreshSecrets(ctx))
+	assert.Len(t, changes, 1)
+
+	resolver.set("API_KEY", "key2", "2")
+	resolver.set("DB_PASS", "v3", "3")
+	assert.NoError(t, k.RefreshSecrets(ctx))
+	assert.Equal(t, []SecretChange{
+		{Key: "password", Secret: "DB_PASS", OldVersion: "1", NewVersion: "2"},
+		{Key: "api_key", Secret: "API_KEY", OldVersion: "1", NewVersion: "2"},
+		{Key: "password", Secret: "DB_PASS", OldVersion: "2", NewVersion: "3"},
+	}, changes, "changes must be reported in key order")
+	assert.Equal(t, "v3", k.Current().String("password"))
+	assert.Equal(t, "key2", k.Current().String("api_key"))
+}
+
+func TestOnSecretChangeWithoutVersions(t *testing.T) {
+	ctx := context.Background()
+	resolver := &fakeVersionedSecretResolver{
+		secrets:  map[string]string{"DB_PASS": "v1"},
+		versions: map[string]string{},
+	}
+	k := NewKonfig(testProjectSet, "us-central1", WithSecretResolver(unversionedSecretResolver{resolver}))
+
+	type Config struct {
+		Password string `koanf:"password" gcpsecret:"DB_PASS"`
+	}
+
+	err := k.InitializeConfig(ctx, &Config{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	changes := make(chan SecretChange, 1)
+	k.OnSecretChange(func(change SecretChange) {
+		changes <- change
+	})
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	k.WatchSecrets(watchCtx, 10*time.Millisecond, func(err error) {
+		t.Error(err)
+	})
+
+	resolver.set("DB_PASS", "v2", "")
+
+	select {
+	case change := <-changes:
+		assert.Equal(t, SecretChange{Key: "password", Secret: "DB_PASS"}, change)
+	case <-time.After(time.Second):
+		t.Fatal("no secret change reported")
+	}
+}
+
+func TestRefreshSecretsWithoutSecrets(t *testing.T) {
+	k := NewKonfig(testProjectSet, "us-central1")
+	assert.NoError(t, k.RefreshSecrets(context.Background()))
+}
+
+func TestOnSecretChangeReportsSecretName(t *testing.T) {
+	ctx := context.Background()
+	resolver := &fakeVersionedSecretResolver{
+		secrets:  map[string]string{"shared-infra/DB_PASS@latest,optional": "v1"},
+		versions: map[string]string{"shared-infra/DB_PASS@latest,optional": "1"},
+	}
+	k := NewKonfig(testProjectSet, "us-central1", WithSecretResolver(resolver))
+
+	type Config struct {
+		Password string `koanf:"password" gcpsecret:"shared-infra/DB_PASS@latest,optional"`
+	}
+
+	err := k.InitializeConfig(ctx, &Config{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var changes []SecretChange
+	k.OnSecretChange(func(change SecretChange) {
+		changes = append(changes, change)
+	})
+
+	resolver.set("shared-infra/DB_PASS@latest,optional", "v2", "2")
+	assert.NoError(t, k.RefreshSecrets(ctx))
+	assert.Equal(t, []SecretChange{{Key: "password", Secret: "DB_PASS", OldVersion: "1", NewVersion: "2"}}, changes)
+}
+
+func TestWatchSecretsWhileReading(t *testing.T) {
+	ctx := context.Background()
+	resolver := &fakeVersionedSecretResolver{
+		secrets:  map[string]string{"DB_PASS": "v1"},
+		versions: map[string]string{"DB_PASS": "1"},
+	}
+	k := NewKonfig(testProjectSet, "us-central1", WithSecretResolver(resolver))
+
+	type Config struct {
+		Password string `koanf:"password" gcpsecret:"DB_PASS"`
+	}
+
+	err := k.InitializeConfig(ctx, &Config{})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	changes := make(chan SecretChange, 1)
+	k.OnSecretChange(func(change SecretChange) {
+		changes <- change
+	})
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	k.WatchSecrets(watchCtx, time.Millisecond, func(err error) {
+		t.Error(err)
+	})
+
+	resolver.set("DB_PASS", "v2", "2")
+
+	// Run with -race: reads must not race with the refreshes
+	deadline := time.After(time.Second)
+	for {
+		assert.Equal(t, "v1", k.String("password"))
+		_ = k.Current().String("password")
+		select {
+		case <-changes:
+			assert.Equal(t, "v2", k.Current().String("password"))
+			return
+		case <-deadline:
+			t.Fatal("no secret change reported")
+		default:
+		}
+	}
+}
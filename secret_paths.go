@@ -0,0 +1,118 @@
+package konfig
+
+import (
+	"fmt"
+	"github.com/knadh/koanf/providers/confmap"
+	"github.com/knadh/koanf/v2"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+// hasValue reports whether key holds a value itself in the config. Keys that
+// only hold child keys do not count, so structured secrets are merged over
+// them. Unlike Exists it understands keys that index into slices, such as
+// tenants.0.password.
+func (k *Konfig) hasValue(raw map[string]interface{}, key string) bool {
+	value, ok := lookupPath(raw, strings.Split(key, k.Delim()))
+	if _, isMap := value.(map[string]interface{}); isMap {
+		return false
+	}
+	return ok
+}
+
+// loadSecretValues loads secret values keyed by koanf key into ko. koanf
+// only unflattens keys into maps, so keys that index into a slice, such as
+// tenants.0.password, are set in the existing slice element instead.
+func loadSecretValues(ko *koanf.Koanf, values map[string]interface{}) error {
+	raw := ko.Raw()
+	plain := make(map[string]interface{}, len(values))
+	indexed := map[string]interface{}{}
+	for key, value := range values {
+		path := strings.Split(key, ko.Delim())
+		ok, err := setIndexedPath(raw, path, value)
+		if err != nil {
+			return errors.Wrap(err, key)
+		}
+		if !ok {
+			plain[key] = value
+			continue
+		}
+		indexed[path[0]] = raw[path[0]]
+	}
+
+	if len(indexed) != 0 {
+		if err := ko.Load(confmap.Provider(indexed, ""), nil); err != nil {
+			return err
+		}
+	}
+	return ko.Load(confmap.Provider(plain, ko.Delim()), nil)
+}
+
+// lookupPath returns the value at path in node, using numeric path segments
+// as slice indexes.
+func lookupPath(node interface{}, path []string) (interface{}, bool) {
+	for _, segment := range path {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			child, ok := n[segment]
+			if !ok {
+				return nil, false
+			}
+			node = child
+		case []interface{}:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(n) {
+				return nil, false
+			}
+			node = n[i]
+		default:
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// setIndexedPath sets value at path in tree if the path goes through a slice,
+// creating missing maps below the slice element. It reports false, leaving
+// tree untouched, for paths that do not go through a slice.
+func setIndexedPath(tree map[string]interface{}, path []string, value interface{}) (bool, error) {
+	var node interface{} = tree
+	indexed := false
+	for i, segment := range path[:len(path)-1] {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			child, ok := n[segment]
+			if !ok {
+				if !indexed {
+					return false, nil
+				}
+				child = map[string]interface{}{}
+				n[segment] = child
+			}
+			node = child
+		case []interface{}:
+			idx, err := strconv.Atoi(segment)
+			if err != nil || idx < 0 || idx >= len(n) {
+				return false, errors.New(fmt.Sprintf("%s has no element %s", strings.Join(path[:i], "."), segment))
+			}
+			indexed = true
+			node = n[idx]
+		default:
+			if !indexed {
+				return false, nil
+			}
+			return false, errors.New(fmt.Sprintf("%s is not a map", strings.Join(path[:i], ".")))
+		}
+	}
+
+	if !indexed {
+		return false, nil
+	}
+	m, ok := node.(map[string]interface{})
+	if !ok {
+		return false, errors.New(fmt.Sprintf("%s is not a map", strings.Join(path[:len(path)-1], ".")))
+	}
+	m[path[len(path)-1]] = value
+	return true, nil
+}
@@ -0,0 +1,65 @@
+package konfig
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSecretsInSlicesMapsAndPointers(t *testing.T) {
+	ctx := context.Background()
+	k := NewKonfig(
+		testProjectSet,
+		"us-central1",
+		WithDefaults(Defaults{
+			"tenants": []interface{}{
+				map[string]interface{}{"name": "acme"},
+				map[string]interface{}{"name": "globex", "password": "preset"},
+			},
+			"backends": map[string]interface{}{
+				"eu": map[string]interface{}{"url": "https://eu.example.com"},
+			},
+		}),
+		WithSecretResolver(fakeSecretResolver{"TENANT_PASS": "tenant", "BACKEND_PASS": "backend", "DB_PASS": "db"}))
+
+	type Tenant struct {
+		Name     string `koanf:"name"`
+		Password string `koanf:"password" gcpsecret:"TENANT_PASS"`
+	}
+
+	type Backend struct {
+		Url      string `koanf:"url"`
+		Password string `koanf:"password" gcpsecret:"BACKEND_PASS"`
+	}
+
+	type DBConfig struct {
+		Password string `koanf:"password" gcpsecret:"DB_PASS"`
+	}
+
+	type Config struct {
+		Tenants  []Tenant           `koanf:"tenants"`
+		Backends map[string]Backend `koanf:"backends"`
+		DB       *DBConfig          `koanf:"db"`
+	}
+
+	cfg := &Config{}
+	err := k.InitializeConfig(ctx, cfg)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, []Tenant{{Name: "acme", Password: "tenant"}, {Name: "globex", Password: "preset"}}, cfg.Tenants)
+	assert.Equal(t, map[string]Backend{"eu": {Url: "https://eu.example.com", Password: "backend"}}, cfg.Backends)
+	assert.Equal(t, &DBConfig{Password: "db"}, cfg.DB)
+
+	assert.True(t, k.IsSecret("tenants.0.password"))
+	assert.False(t, k.IsSecret("tenants.1.password"))
+	redacted := k.Redacted()
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"name": "acme", "password": "[REDACTED]"},
+		map[string]interface{}{"name": "globex", "password": "preset"},
+	}, redacted["tenants"])
+	assert.Equal(t, "[REDACTED]", redacted["backends.eu.password"])
+	assert.Equal(t, "[REDACTED]", redacted["db.password"])
+	assert.Equal(t, "tenant", k.Get("tenants").([]interface{})[0].(map[string]interface{})["password"], "Redacted must not modify the config")
+}
@@ -0,0 +1,85 @@
+package konfig
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// secretNameVariable matches ${env}, ${runtime}, ${project} and ${region}
+// placeholders in secret references.
+var secretNameVariable = regexp.MustCompile(`\$\{(env|runtime|project|region)\}`)
+
+var secretNameFuncs = template.FuncMap{
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// secretNameData is the data available to templated secret references, e.g.
+// gcpsecret:"{{.Env}}_DB_PASS" or gcpsecret:"{{upper .Env}}_DB_PASS".
+type secretNameData struct {
+	Env     string
+	Runtime string
+	Project string
+	Region  string
+}
+
+// expandSecretNames fills in templated secret references with the env,
+// runtime, project and region of the config. References can use Go template
+// syntax, e.g. {{.Env}}_DB_PASS, or placeholders, e.g. ${region}-api-key.
+func (k *Konfig) expandSecretNames(secrets map[string]string) (map[string]string, error) {
+	data := secretNameData{
+		Env:     k.secretNameValue(envKey),
+		Runtime: k.secretNameValue(runtimeKey),
+		Project: k.secretNameValue(projectKey),
+		Region:  k.secretNameValue(regionKey),
+	}
+
+	expanded := make(map[string]string, len(secrets))
+	for key, ref := range secrets {
+		name, err := expandSecretName(ref, data)
+		if err != nil {
+			return nil, errors.Wrap(err, key)
+		}
+		expanded[key] = name
+	}
+	return expanded, nil
+}
+
+func (k *Konfig) secretNameValue(key string) string {
+	if !k.Exists(key) {
+		return ""
+	}
+	return fmt.Sprint(k.Get(key))
+}
+
+func expandSecretName(ref string, data secretNameData) (string, error) {
+	ref = secretNameVariable.ReplaceAllStringFunc(ref, func(placeholder string) string {
+		switch secretNameVariable.FindStringSubmatch(placeholder)[1] {
+		case envKey:
+			return data.Env
+		case runtimeKey:
+			return data.Runtime
+		case projectKey:
+			return data.Project
+		default:
+			return data.Region
+		}
+	})
+
+	if !strings.Contains(ref, "{{") {
+		return ref, nil
+	}
+
+	tmpl, err := template.New("secret").Funcs(secretNameFuncs).Option("missingkey=error").Parse(ref)
+	if err != nil {
+		return "", errors.Wrap(err, "invalid secret name template")
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", errors.Wrap(err, "invalid secret name template")
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,67 @@
+package konfig
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTemplatedSecretNames(t *testing.T) {
+	ctx := context.Background()
+	k := NewKonfig(
+		testProjectSet,
+		"us-central1",
+		WithSecretResolver(fakeSecretResolver{
+			"DEV_DB_PASS":                "db",
+			"us-central1-api-key":        "api",
+			"playground-mscno/dev-token": "token",
+			"test_SENTRY_DSN":            "sentry",
+		}))
+	k.SetRuntime(TEST)
+
+	type Config struct {
+		DbPass    string `koanf:"db_pass" gcpsecret:"{{upper .Env}}_DB_PASS"`
+		ApiKey    string `koanf:"api_key" gcpsecret:"${region}-api-key"`
+		Token     string `koanf:"token" gcpsecret:"{{.Project}}/${env}-token"`
+		SentryDsn string `koanf:"sentry_dsn" gcpsecret:"${runtime}_SENTRY_DSN"`
+	}
+
+	cfg := &Config{}
+	err := k.InitializeConfig(ctx, cfg)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assert.Equal(t, "db", cfg.DbPass)
+	assert.Equal(t, "api", cfg.ApiKey)
+	assert.Equal(t, "token", cfg.Token)
+	assert.Equal(t, "sentry", cfg.SentryDsn)
+}
+
+func TestExpandSecretName(t *testing.T) {
+	data := secretNameData{Env: "prod", Runtime: "cloud", Project: "app-prod", Region: "europe-west1"}
+
+	tests := []struct {
+		name    string
+		ref     string
+		want    string
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{"plain", "DB_PASS", "DB_PASS", assert.NoError},
+		{"template", "{{.Env}}_DB_PASS", "prod_DB_PASS", assert.NoError},
+		{"template func", "{{upper .Env}}_DB_PASS,optional", "PROD_DB_PASS,optional", assert.NoError},
+		{"placeholder", "${region}-api-key@3", "europe-west1-api-key@3", assert.NoError},
+		{"unknown placeholder", "${zone}-api-key", "${zone}-api-key", assert.NoError},
+		{"unknown field", "{{.Zone}}_DB_PASS", "", assert.Error},
+		{"invalid template", "{{.Env_DB_PASS", "", assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := expandSecretName(tt.ref, data)
+			if !tt.wantErr(t, err) {
+				return
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}